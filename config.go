@@ -51,6 +51,7 @@ func (c *Config) Fprint(w io.Writer, conf string) error {
 		var sqls []string
 		sqls = append(sqls, store.CreateSql...)
 		sqls = append(sqls, feeds.CreateSql...)
+		sqls = append(sqls, feeds.AlterSql()...)
 		for _, s := range sqls {
 			_, err := fmt.Fprintln(w, s)
 			if err != nil {
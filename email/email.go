@@ -6,12 +6,17 @@ package email
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/mail"
 	"net/textproto"
 	"strings"
+	"time"
 
 	"mime/quotedprintable"
 )
@@ -131,9 +136,84 @@ func NewPart(typ, enc string, r io.Reader) *Part {
 	return &Part{h, r}
 }
 
+// NewBase64 returns a base64 encoded part.
+func NewBase64(typ string, r io.Reader) (*Part, error) {
+	var buf bytes.Buffer
+	lw := &lineWriter{w: &buf}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	_, err := io.Copy(enc, r)
+	if err != nil {
+		return nil, err
+	}
+	enc.Close()
+	lw.Close()
+	return NewPart(typ, `base64`, &buf), nil
+}
+
+// NewInline returns a base64 encoded inline part that can be referenced by cid.
+func NewInline(typ, cid string, r io.Reader) (*Part, error) {
+	p, err := NewBase64(typ, r)
+	if err != nil {
+		return nil, err
+	}
+	p.Header.Set("Content-Id", "<"+cid+">")
+	p.Header.Set("Content-Disposition", "inline")
+	return p, nil
+}
+
+// NewAttachment returns a base64 encoded attachment part with the file name.
+func NewAttachment(typ, filename string, r io.Reader) (*Part, error) {
+	p, err := NewBase64(typ, r)
+	if err != nil {
+		return nil, err
+	}
+	disp := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disp == "" {
+		disp = "attachment"
+	}
+	p.Header.Set("Content-Disposition", disp)
+	return p, nil
+}
+
+// NewMultipart returns a part of the multipart subtype containing parts.
+func NewMultipart(subtype string, parts ...Part) (*Part, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	err := writeParts(mw, parts)
+	if err != nil {
+		return nil, err
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", multipartType(subtype, mw.Boundary(), parts))
+	return &Part{h, &buf}, nil
+}
+
+// multipartType returns the content type for a multipart subtype. Related
+// parts have a type parameter with the type of the root part.
+func multipartType(subtype, boundary string, parts []Part) string {
+	if subtype == "" {
+		subtype = "mixed"
+	}
+	params := map[string]string{"boundary": boundary}
+	if subtype == "related" && len(parts) > 0 {
+		root, _, err := mime.ParseMediaType(parts[0].Header.Get("Content-Type"))
+		if err == nil {
+			params["type"] = root
+		}
+	}
+	return mime.FormatMediaType("multipart/"+subtype, params)
+}
+
 type Msg struct {
 	Header textproto.MIMEHeader
-	Parts  []Part
+	// Multipart is the subtype used for messages with multiple parts.
+	// Messages are multipart/mixed if it is empty.
+	Multipart string
+	Parts     []Part
 }
 
 func NewMsg(from Addr, subject string, to ...Addr) *Msg {
@@ -148,7 +228,41 @@ func NewMsg(from Addr, subject string, to ...Addr) *Msg {
 	return &Msg{Header: h}
 }
 
-func (m *Msg) WriteTo(w io.Writer) error {
+// MessageId returns a stable message id for domain derived from the parts.
+func MessageId(domain string, parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(h.Sum(nil)[:16]), domain)
+}
+
+// SetDate sets the date header in rfc 5322 format.
+func (m *Msg) SetDate(t time.Time) {
+	m.Header.Set("Date", t.Format(time.RFC1123Z))
+}
+
+// SetMessageId sets the message id header.
+func (m *Msg) SetMessageId(id string) {
+	m.Header.Set("Message-Id", id)
+}
+
+// SetInReplyTo marks the message as reply to the message with id.
+func (m *Msg) SetInReplyTo(id string) {
+	m.Header.Set("In-Reply-To", id)
+	m.Header.Set("References", id)
+}
+
+// WriteTo writes the message to w and returns the number of bytes written.
+// It implements io.WriterTo, go vet rejects WriteTo methods with other signatures.
+func (m *Msg) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	err := m.write(cw)
+	return cw.n, err
+}
+
+func (m *Msg) write(w io.Writer) error {
 	if len(m.Parts) == 0 {
 		return fmt.Errorf("no message content")
 	}
@@ -188,12 +302,16 @@ func (m *Msg) writeHeader(w io.Writer) error {
 func (m *Msg) writeMultipart(w io.Writer) error {
 	mw := multipart.NewWriter(w)
 	defer mw.Close()
-	m.Header.Set("Content-Type", `multipart/mixed; boundary=`+mw.Boundary())
+	m.Header.Set("Content-Type", multipartType(m.Multipart, mw.Boundary(), m.Parts))
 	err := m.writeHeader(w)
 	if err != nil {
 		return err
 	}
-	for _, p := range m.Parts {
+	return writeParts(mw, m.Parts)
+}
+
+func writeParts(mw *multipart.Writer, parts []Part) error {
+	for _, p := range parts {
 		pw, err := mw.CreatePart(p.Header)
 		if err != nil {
 			return err
@@ -205,22 +323,112 @@ func (m *Msg) writeMultipart(w io.Writer) error {
 	}
 	return nil
 }
-func (m *Msg) AddQuotedPrintable(typ string, r io.Reader) error {
+
+// Content types of utf-8 encoded text parts.
+const (
+	PlainType = `text/plain; charset="utf-8"`
+	HtmlType  = `text/html; charset="utf-8"`
+)
+
+// NewQuotedPrintable returns a quoted-printable encoded part.
+func NewQuotedPrintable(typ string, r io.Reader) (*Part, error) {
 	buf := &bytes.Buffer{}
 	enc := quotedprintable.NewWriter(buf)
 	_, err := io.Copy(enc, r)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return NewPart(typ, `quoted-printable`, buf), nil
+}
+
+func (m *Msg) AddQuotedPrintable(typ string, r io.Reader) error {
+	p, err := NewQuotedPrintable(typ, r)
 	if err != nil {
 		return err
 	}
-	p := NewPart(typ, `quoted-printable`, buf)
 	m.Parts = append(m.Parts, *p)
 	return nil
 }
 
 func (m *Msg) AddPlain(r io.Reader) error {
-	return m.AddQuotedPrintable(`text/plain; charset="utf-8"`, r)
+	return m.AddQuotedPrintable(PlainType, r)
 }
 
 func (m *Msg) AddHtml(r io.Reader) error {
-	return m.AddQuotedPrintable(`text/html; charset="utf-8"`, r)
+	return m.AddQuotedPrintable(HtmlType, r)
+}
+
+// AddAttachment adds a base64 encoded attachment with the file name.
+func (m *Msg) AddAttachment(typ, filename string, r io.Reader) error {
+	p, err := NewAttachment(typ, filename, r)
+	if err != nil {
+		return err
+	}
+	m.Parts = append(m.Parts, *p)
+	return nil
+}
+
+// AddInline adds a base64 encoded inline part that can be referenced by cid.
+func (m *Msg) AddInline(typ, cid string, r io.Reader) error {
+	p, err := NewInline(typ, cid, r)
+	if err != nil {
+		return err
+	}
+	m.Parts = append(m.Parts, *p)
+	return nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// lineWriter breaks the written bytes into lines of 76 characters.
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		l := 76 - w.col
+		if l > len(p) {
+			l = len(p)
+		}
+		c, err := w.w.Write(p[:l])
+		n += c
+		if err != nil {
+			return n, err
+		}
+		p = p[l:]
+		w.col += l
+		if w.col == 76 {
+			w.col = 0
+			if _, err := io.WriteString(w.w, "\r\n"); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close terminates the last line.
+func (w *lineWriter) Close() error {
+	if w.col == 0 {
+		return nil
+	}
+	w.col = 0
+	_, err := io.WriteString(w.w, "\r\n")
+	return err
 }
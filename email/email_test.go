@@ -6,6 +6,11 @@ package email
 
 import (
 	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/mail"
 	"testing"
 )
@@ -87,3 +92,95 @@ func TestMsg(t *testing.T) {
 		t.Errorf("body: expect %s got %s\n", expectBody, got)
 	}
 }
+
+func TestMessageId(t *testing.T) {
+	id := MessageId("feeds", "1", "key")
+	if id != MessageId("feeds", "1", "key") {
+		t.Error("expect stable message id")
+	}
+	if id == MessageId("feeds", "1", "key", "hash") || id == MessageId("feeds", "1k", "ey") {
+		t.Error("expect distinct message ids")
+	}
+	addr, err := mail.ParseAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addr.Address) != 32+len("@feeds") {
+		t.Errorf("unexpected message id %s", id)
+	}
+	m := NewMsg(Sender, "reply", Sender)
+	m.SetMessageId(MessageId("feeds", "2"))
+	m.SetInReplyTo(id)
+	if m.Header.Get("In-Reply-To") != id || m.Header.Get("References") != id {
+		t.Errorf("unexpected reply headers %v", m.Header)
+	}
+}
+
+func TestMultipartRelated(t *testing.T) {
+	m := NewMsg(Sender, "images", Sender)
+	m.Multipart = "related"
+	err := m.AddHtml(bytes.NewReader([]byte(`<img src="cid:img1@feeds">`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := bytes.Repeat([]byte{0, 1, 2, 3, 4, 5, 6, 7}, 32)
+	err = m.AddInline("image/png", "img1@feeds", bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("expect %d bytes written got %d", buf.Len(), n)
+	}
+	mm, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ, params, err := mime.ParseMediaType(mm.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != "multipart/related" || params["type"] != "text/html" {
+		t.Fatalf("unexpected content type %s %v", typ, params)
+	}
+	mr := multipart.NewReader(mm.Body, params["boundary"])
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid := p.Header.Get("Content-Id"); cid != "<img1@feeds>" {
+		t.Errorf("unexpected content id %s", cid)
+	}
+	data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, img) {
+		t.Errorf("expect image data %v got %v", img, data)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expect two parts got %v", err)
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	p, err := NewAttachment("audio/mpeg", "episode 1.mp3", bytes.NewReader([]byte("ID3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != "episode 1.mp3" {
+		t.Errorf("unexpected disposition %s", p.Header.Get("Content-Disposition"))
+	}
+	data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p.Content))
+	if err != nil || string(data) != "ID3" {
+		t.Errorf("unexpected content %q %v", data, err)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mb0/vmail/feeds"
+)
+
+// job is the schedule state of a feeder in the feed daemon.
+type job struct {
+	feeds.Feeder
+	next    time.Time
+	running bool
+	last    time.Time
+	err     error
+	// subscribed is the time of the last websub subscription request.
+	subscribed time.Time
+}
+
+type daemon struct {
+	p        *prog
+	interval time.Duration
+	callback string
+	pool     *feeds.Pool
+	quit     chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	jobs     map[int64]*job
+	// locks serializes checks and pushes of the same feeder
+	locks map[int64]*sync.Mutex
+}
+
+// feedd checks all feeds on their schedule until it receives SIGTERM or SIGINT.
+// It reloads the feeders every minute and dumps its status on SIGUSR1.
+// If callback is set, feeds advertising a websub hub are subscribed and the
+// pushed content is received by a listener on the listen address.
+func (p *prog) feedd(workers int, interval time.Duration, listen, callback string) error {
+	if workers < 1 {
+		return fmt.Errorf("feedd requires at least one worker")
+	}
+	if callback != "" && listen == "" {
+		return fmt.Errorf("websub callback requires a listen address")
+	}
+	p.daemon = true
+	p.wr = feeds.NewWriter(open(p.conf))
+	defer func() {
+		p.wr.Close()
+		p.wr.DB().Close()
+	}()
+	d := &daemon{
+		p:        p,
+		interval: interval,
+		callback: callback,
+		pool:     feeds.NewPool(workers, p.perhost),
+		quit:     make(chan struct{}),
+		jobs:     make(map[int64]*job),
+		locks:    make(map[int64]*sync.Mutex),
+	}
+	// the listener is shut down before waiting for the pushes it started
+	shutdown := func() {}
+	if listen != "" {
+		srv := &http.Server{
+			Addr:         listen,
+			Handler:      d.websub(),
+			ReadTimeout:  time.Minute,
+			WriteTimeout: 5 * time.Minute,
+		}
+		go func() {
+			err := srv.ListenAndServe()
+			if err != http.ErrServerClosed {
+				log.Println("websub listener:", err)
+			}
+		}()
+		shutdown = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			srv.Shutdown(ctx)
+		}
+		log.Printf("websub listening on %s", listen)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	log.Printf("feedd started with %d workers and %d per host", workers, p.perhost)
+	for {
+		err := d.reload()
+		if err != nil {
+			log.Println("reloading feeders:", err)
+		}
+		d.dispatch()
+		select {
+		case s := <-sig:
+			if s == syscall.SIGUSR1 {
+				d.status()
+				continue
+			}
+			log.Printf("feedd received %s, waiting for running checks", s)
+			shutdown()
+			close(d.quit)
+			d.wg.Wait()
+			log.Println("feedd stopped")
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+// reload updates the jobs from the feeder table, adding new and removing deleted
+// feeders. Running jobs are updated after they finished.
+func (d *daemon) reload() error {
+	db := open(d.p.conf)
+	defer db.Close()
+	fs, err := feeds.Feeders(db, "where enable=1")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := make(map[int64]bool, len(fs))
+	for _, f := range fs {
+		seen[f.Id] = true
+		j := d.jobs[f.Id]
+		if j == nil {
+			j = &job{}
+			d.jobs[f.Id] = j
+		} else if j.running {
+			continue
+		}
+		j.Feeder = f
+		interval := d.interval
+		if f.Leased(now) && interval < feeds.PushInterval {
+			// poll rarely while the hub pushes new content
+			interval = feeds.PushInterval
+		}
+		j.next = f.Next(interval)
+		if d.callback != "" && f.NeedsLease(now) && now.Sub(j.subscribed) > time.Hour {
+			j.subscribed = now
+			d.wg.Add(1)
+			go d.subscribe(f)
+		}
+	}
+	for id, j := range d.jobs {
+		if !seen[id] && !j.running {
+			delete(d.jobs, id)
+		}
+	}
+	return nil
+}
+
+// dispatch starts all due jobs. At most one check per worker and the per host
+// limit of checks for feeds on the same host run at a time.
+func (d *daemon) dispatch() {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, j := range d.jobs {
+		if j.running || j.next.After(now) {
+			continue
+		}
+		j.running = true
+		d.wg.Add(1)
+		go d.run(j, j.Feeder)
+	}
+}
+
+func (d *daemon) run(j *job, f feeds.Feeder) {
+	defer d.wg.Done()
+	release := d.pool.Acquire(f.Url, d.quit)
+	if release == nil {
+		return
+	}
+	unlock := d.lock(f.Id)
+	err := d.p.check(f)
+	unlock()
+	release()
+	if err != nil {
+		log.Printf("%s: %v", f.Name, err)
+	}
+	d.mu.Lock()
+	j.running = false
+	j.last = time.Now()
+	j.err = err
+	d.mu.Unlock()
+}
+
+// lock locks the feeder with id and returns the unlock function.
+func (d *daemon) lock(id int64) func() {
+	d.mu.Lock()
+	l := d.locks[id]
+	if l == nil {
+		l = new(sync.Mutex)
+		d.locks[id] = l
+	}
+	d.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// subscribe requests a websub subscription from the hub of feeder f. The request
+// is stored as pending and the lease once the hub verified the subscription.
+func (d *daemon) subscribe(f feeds.Feeder) {
+	defer d.wg.Done()
+	if f.Secret == "" {
+		secret, err := feeds.NewSecret()
+		if err != nil {
+			log.Printf("%s: %v", f.Name, err)
+			return
+		}
+		f.Secret = secret
+	}
+	err := f.NewHubRequest("subscribe", time.Now())
+	if err == nil {
+		err = d.p.write(nil, f.SaveHub)
+	}
+	if err != nil {
+		log.Printf("%s: %v", f.Name, err)
+		return
+	}
+	err = f.HubRequest(feeds.CallbackUrl(d.callback, f.Id))
+	if err != nil {
+		log.Printf("%s: websub subscription: %v", f.Name, err)
+		return
+	}
+	log.Printf("%s: requested websub subscription from %s", f.Name, f.Hub)
+}
+
+// websub returns the handler for websub callbacks.
+func (d *daemon) websub() http.Handler {
+	return &feeds.Callback{
+		Feeder: func(id int64) (*feeds.Feeder, error) {
+			fs, err := feeds.Feeders(d.p.wr.DB(), "where id=?", id)
+			if err != nil || len(fs) == 0 {
+				return nil, err
+			}
+			return &fs[0], nil
+		},
+		Verified: func(f *feeds.Feeder) error {
+			if f.Lease != nil {
+				log.Printf("%s: websub lease until %s", f.Name, f.Lease.Format(time.Stamp))
+			} else {
+				log.Printf("%s: websub unsubscribed", f.Name)
+			}
+			return d.p.write(nil, f.SaveHub)
+		},
+		Push: func(f *feeds.Feeder, entries []feeds.Entry) error {
+			// answer the hub right away, delivery may wait for a running check
+			d.wg.Add(1)
+			go d.push(f.Id, f.Name, entries)
+			return nil
+		},
+	}
+}
+
+// push delivers the entries pushed for the feeder with id once no check of it
+// is running.
+func (d *daemon) push(id int64, name string, entries []feeds.Entry) {
+	defer d.wg.Done()
+	defer d.lock(id)()
+	// reload the feeder, a check may have changed it while we waited
+	fs, err := feeds.Feeders(d.p.wr.DB(), "where id=?", id)
+	if err != nil {
+		log.Printf("%s: websub push: %v", name, err)
+		return
+	}
+	if len(fs) == 0 || !fs[0].Enable {
+		log.Printf("%s: ignored %d pushed entries", name, len(entries))
+		return
+	}
+	log.Printf("%s: received %d pushed entries", name, len(entries))
+	err = d.p.push(fs[0], entries)
+	if err != nil {
+		log.Printf("%s: websub push: %v", name, err)
+	}
+}
+
+type byNext []*job
+
+func (l byNext) Len() int           { return len(l) }
+func (l byNext) Less(i, j int) bool { return l[i].next.Before(l[j].next) }
+func (l byNext) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// status prints the schedule of all jobs to stderr.
+func (d *daemon) status() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]*job, 0, len(d.jobs))
+	for _, j := range d.jobs {
+		list = append(list, j)
+	}
+	sort.Sort(byNext(list))
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "feed\tstate\tlast\tnext\terror")
+	for _, j := range list {
+		state, last, errstr := "idle", "-", ""
+		if j.running {
+			state = "running"
+		}
+		if !j.last.IsZero() {
+			last = j.last.Format(time.Stamp)
+		}
+		if j.err != nil {
+			errstr = j.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", j.Name, state, last, j.next.Format(time.Stamp), errstr)
+	}
+	w.Flush()
+}
@@ -0,0 +1,201 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Atom 1.0 as specified in RFC 4287
+
+type AtomFeed struct {
+	Title    AtomText    `xml:"title"`
+	Subtitle AtomText    `xml:"subtitle"`
+	Link     []AtomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Entry    []AtomEntry `xml:"entry"`
+	// syndication module hints
+	UpdatePeriod    string `xml:"updatePeriod"`
+	UpdateFrequency int    `xml:"updateFrequency"`
+}
+
+type AtomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// Html returns the text construct as html.
+func (t AtomText) Html() string {
+	switch t.Type {
+	case "html":
+		return t.Text
+	case "xhtml":
+		return xhtmlContent(t.Inner)
+	}
+	return html.EscapeString(t.Text)
+}
+
+// xhtmlContent returns the content of the xhtml div wrapping the inner xml.
+// The div is not part of the content as specified in RFC 4287 section 3.1.1.3.
+func xhtmlContent(inner string) string {
+	inner = strings.TrimSpace(inner)
+	start := strings.Index(inner, ">")
+	if !strings.HasPrefix(inner, "<") || start < 0 {
+		return inner
+	}
+	fields := strings.Fields(strings.TrimSuffix(inner[1:start], "/"))
+	if len(fields) == 0 || fields[0] != "div" && !strings.HasSuffix(fields[0], ":div") {
+		return inner
+	}
+	if strings.HasSuffix(inner[:start], "/") {
+		return ""
+	}
+	end := "</" + fields[0] + ">"
+	if !strings.HasSuffix(inner, end) {
+		return inner
+	}
+	return strings.TrimSpace(inner[start+1 : len(inner)-len(end)])
+}
+
+// Plain returns the text construct as plain text.
+func (t AtomText) Plain() string {
+	switch t.Type {
+	case "html":
+		return htmlPlain(t.Text)
+	case "xhtml":
+		return htmlPlain(xhtmlContent(t.Inner))
+	}
+	return t.Text
+}
+
+// htmlPlain returns the text of the html fragment with collapsed white space.
+func htmlPlain(str string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(str), &html.Node{
+		Type: html.ElementNode, Data: "div", DataAtom: atom.Div,
+	})
+	if err != nil {
+		return html.UnescapeString(str)
+	}
+	var buf bytes.Buffer
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+type AtomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type AtomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type AtomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email"`
+}
+
+type AtomEntry struct {
+	Id        string         `xml:"id"`
+	Title     AtomText       `xml:"title"`
+	Link      []AtomLink     `xml:"link"`
+	Published string         `xml:"published"`
+	Updated   string         `xml:"updated"`
+	Category  []AtomCategory `xml:"category"`
+	Author    []AtomPerson   `xml:"author"`
+	Summary   AtomText       `xml:"summary"`
+	Content   AtomText       `xml:"content"`
+}
+
+func atomLink(links []AtomLink, rel string) *AtomLink {
+	for i, l := range links {
+		if l.Rel == rel || rel == "alternate" && l.Rel == "" {
+			return &links[i]
+		}
+	}
+	return nil
+}
+
+// atomDate reformats rfc 3339 dates to the rfc 1123 format used in rss.
+func atomDate(s string) string {
+	t, err := ParseDate(s)
+	if err != nil {
+		return s
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+// Feed normalizes the atom feed to a rss feed.
+func (a *AtomFeed) Feed() *Feed {
+	f := &Feed{Type: TypeAtom}
+	f.Channel.Title = a.Title.Plain()
+	f.Channel.Description = a.Subtitle.Text
+	if l := atomLink(a.Link, "alternate"); l != nil {
+		f.Channel.Link = l.Href
+	}
+	f.Channel.AtomLink = a.Link
+	f.Channel.LastBuildDate = atomDate(a.Updated)
+	f.Channel.UpdatePeriod = a.UpdatePeriod
+	f.Channel.UpdateFrequency = a.UpdateFrequency
+	f.Channel.Item = make([]Item, 0, len(a.Entry))
+	for _, e := range a.Entry {
+		it := Item{
+			Title:       e.Title.Plain(),
+			GUID:        e.Id,
+			PubDate:     atomDate(e.Published),
+			Updated:     atomDate(e.Updated),
+			Description: e.Summary.Html(),
+			Content:     e.Content.Html(),
+		}
+		if it.PubDate == "" {
+			it.PubDate = it.Updated
+		}
+		if l := atomLink(e.Link, "alternate"); l != nil {
+			it.Link = l.Href
+		}
+		if l := atomLink(e.Link, "enclosure"); l != nil {
+			it.Enclosure = ItemEnclosure{URL: l.Href, Type: l.Type}
+			it.Enclosure.Length, _ = strconv.ParseInt(l.Length, 10, 64)
+		}
+		if len(e.Author) > 0 {
+			it.Author = e.Author[0].Name
+		}
+		for _, c := range e.Category {
+			it.Category = append(it.Category, c.Term)
+		}
+		f.Channel.Item = append(f.Channel.Item, it)
+	}
+	return f
+}
+
+func decodeAtom(dec *xml.Decoder) (*Feed, error) {
+	var a AtomFeed
+	if err := dec.Decode(&a); err != nil {
+		return nil, err
+	}
+	return a.Feed(), nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// How the categories of entries are used. The modes are bit flags.
+const (
+	CategoryKeywords = 1 << iota
+	CategoryFolders
+)
+
+var categoryModes = []string{"off", "keywords", "folders", "both"}
+
+// ParseCategories returns the category mode named str.
+func ParseCategories(str string) (int, error) {
+	for mode, name := range categoryModes {
+		if name == str {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("categories must be one of %s", strings.Join(categoryModes, ", "))
+}
+
+// FormatCategories returns the name of category mode.
+func FormatCategories(mode int) string {
+	if mode < 0 || mode >= len(categoryModes) {
+		return "unknown"
+	}
+	return categoryModes[mode]
+}
+
+// Keyword returns the category as imap keyword. Keywords are atoms, so white
+// space and special characters, including the backslash of system flags, are
+// replaced by underscores.
+func Keyword(category string) string {
+	fields := strings.Fields(category)
+	b := make([]byte, 0, len(category))
+	for i, f := range fields {
+		if i > 0 {
+			b = append(b, '_')
+		}
+		for _, c := range []byte(f) {
+			if c < 0x21 || c > 0x7e || strings.IndexByte(`()[]{}%*"\`, c) >= 0 {
+				c = '_'
+			}
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// Keywords returns the unique categories of the entry as imap keywords.
+func (e *Entry) Keywords() []string {
+	var res []string
+	seen := make(map[string]bool, len(e.Category))
+	for _, c := range e.Category {
+		k := Keyword(c)
+		if k == "" || seen[strings.ToLower(k)] {
+			continue
+		}
+		seen[strings.ToLower(k)] = true
+		res = append(res, k)
+	}
+	return res
+}
+
+// CategoryFolder returns the folder name of the first usable category of the
+// entry or an empty string.
+func (e *Entry) CategoryFolder() string {
+	for _, c := range e.Category {
+		if name := CleanName(c); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// AddSubfolder records the category folder name as created for the feeder.
+func (f *Feeder) AddSubfolder(db *sql.DB, name string) error {
+	for _, n := range f.Subfolders {
+		if n == name {
+			return nil
+		}
+	}
+	folders := append(f.Subfolders[:len(f.Subfolders):len(f.Subfolders)], name)
+	_, err := db.Exec(`update feeder set catfolders=? where id=?`, strings.Join(folders, " "), f.Id)
+	if err != nil {
+		return err
+	}
+	f.Subfolders = folders
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	e := Entry{Item: Item{Category: []string{
+		" Tech News ", "tech_news", `\Seen`, "(C++)", "", "Politik", "politik",
+	}}}
+	expect := []string{"Tech_News", "_Seen", "_C++_", "Politik"}
+	if got := e.Keywords(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expect keywords %q got %q", expect, got)
+	}
+	if got := e.CategoryFolder(); got != "tech-news" {
+		t.Errorf("expect folder tech-news got %q", got)
+	}
+	e.Category = []string{"!!!", "Sport"}
+	if got := e.CategoryFolder(); got != "sport" {
+		t.Errorf("expect folder sport got %q", got)
+	}
+	e.Category = nil
+	if got := e.CategoryFolder(); got != "" || len(e.Keywords()) != 0 {
+		t.Errorf("expect no folder or keywords got %q", got)
+	}
+}
+
+func TestCategoryModes(t *testing.T) {
+	for _, name := range categoryModes {
+		mode, err := ParseCategories(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if FormatCategories(mode) != name {
+			t.Errorf("expect %s got %s", name, FormatCategories(mode))
+		}
+	}
+	mode, _ := ParseCategories("both")
+	if mode != CategoryKeywords|CategoryFolders {
+		t.Errorf("expect both flags got %d", mode)
+	}
+	if _, err := ParseCategories("tags"); err == nil {
+		t.Error("expect error for unknown mode")
+	}
+}
+
+func TestAddSubfolder(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "news", "http://example.org/news.rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sport", "tech-news", "sport"} {
+		err = f.AddSubfolder(db, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"sport", "tech-news"}
+	if !reflect.DeepEqual(fs[0].Subfolders, expect) || !reflect.DeepEqual(f.Subfolders, expect) {
+		t.Errorf("expect subfolders %q got %q", expect, fs[0].Subfolders)
+	}
+}
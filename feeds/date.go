@@ -0,0 +1,127 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// isoLayouts are rfc 3339 and similar layouts used by atom, json feed and dublin core.
+var isoLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// rfcLayouts are rfc 822 and 1123 layouts used by rss after the week day was
+// removed and named zones were replaced.
+var rfcLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04 -07:00",
+	"Jan 2 2006 15:04:05 -0700",
+}
+
+// ctimeLayouts are used by feeds generated with the default date formats of
+// some languages.
+var ctimeLayouts = []string{
+	time.ANSIC,
+	time.UnixDate,
+	time.RubyDate,
+}
+
+// zones maps named time zones to their offsets.
+var zones = map[string]string{
+	"UT": "+0000", "UTC": "+0000", "GMT": "+0000", "Z": "+0000",
+	"EST": "-0500", "EDT": "-0400", "CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600", "PST": "-0800", "PDT": "-0700",
+	"BST": "+0100", "CET": "+0100", "CEST": "+0200", "MEZ": "+0100",
+	"MESZ": "+0200", "EET": "+0200", "EEST": "+0300", "MSK": "+0300",
+	"JST": "+0900", "KST": "+0900", "AEST": "+1000", "AEDT": "+1100",
+}
+
+// ParseDate parses dates in the formats used by feeds. Dates without zone are
+// in UTC.
+func ParseDate(s string) (time.Time, error) {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	for _, l := range isoLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	for _, l := range ctimeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := parseRfc(s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", s)
+}
+
+func parseRfc(s string) (time.Time, error) {
+	// remove the week day, it is often misspelled and redundant
+	if i := strings.Index(s, ","); i >= 0 && i < 10 {
+		s = strings.TrimSpace(s[i+1:])
+	}
+	fields := strings.Fields(strings.Replace(s, ",", " ", -1))
+	if len(fields) < 4 {
+		return time.Time{}, fmt.Errorf("unknown date format %q", s)
+	}
+	for i, f := range fields {
+		if strings.HasPrefix(f, "Sept") {
+			fields[i] = "Sep" + f[4:]
+		}
+	}
+	last := fields[len(fields)-1]
+	if off, ok := zones[strings.ToUpper(last)]; ok {
+		fields[len(fields)-1] = off
+	} else if strings.Contains(last, ":") && last[0] != '+' && last[0] != '-' {
+		fields = append(fields, "+0000")
+	}
+	s = strings.Join(fields, " ")
+	var err error
+	for _, l := range rfcLayouts {
+		var t time.Time
+		if t, err = time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// PublishedTime returns the publication date of the entry or the zero time.
+func (e *Entry) PublishedTime() time.Time {
+	for _, s := range []string{e.PubDate, e.Date, e.Updated} {
+		if t, err := ParseDate(s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// UpdatedTime returns the date of the last change of the entry or the zero time.
+func (e *Entry) UpdatedTime() time.Time {
+	if t, err := ParseDate(e.Updated); err == nil {
+		return t
+	}
+	return e.PublishedTime()
+}
@@ -0,0 +1,57 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	expect := time.Date(2013, 5, 7, 11, 14, 33, 0, time.UTC)
+	tests := []struct {
+		str    string
+		expect time.Time
+	}{
+		{"Tue, 07 May 2013 11:14:33 +0000", expect},
+		{"Tue, 7 May 2013 11:14:33 GMT", expect},
+		{"Tue, 07 May 2013 07:14:33 EDT", expect},
+		{"Tue, 07 May 2013 13:14:33 +02:00", expect},
+		{"Tues, 07 May 2013 11:14:33 UT", expect},
+		{"07 May 2013 11:14:33 Z", expect},
+		{"Tue, 07 May 13 11:14:33 +0000", expect},
+		{"Tue,  07 May 2013\n11:14:33", expect},
+		{"Tuesday, 07 May 2013 11:14:33 +0000", expect},
+		{"Tue, 07 May 2013 11:14 +0000", expect.Add(-33 * time.Second)},
+		{"Tue, 07 Sept 2013 11:14:33 +0000", expect.AddDate(0, 4, 0)},
+		{"2013-05-07T11:14:33Z", expect},
+		{"2013-05-07T13:14:33+02:00", expect},
+		{"2013-05-07T11:14:33.000Z", expect},
+		{"2013-05-07T11:14:33", expect},
+		{"2013-05-07 11:14:33", expect},
+		{"2013-05-07", time.Date(2013, 5, 7, 0, 0, 0, 0, time.UTC)},
+		{"Tue May  7 11:14:33 2013", expect},
+		{"Tue May 7 11:14:33 UTC 2013", expect},
+	}
+	for _, test := range tests {
+		got, err := ParseDate(test.str)
+		if err != nil {
+			t.Errorf("%s: %v", test.str, err)
+			continue
+		}
+		if !got.Equal(test.expect) {
+			t.Errorf("%s: expect %s got %s", test.str, test.expect, got)
+		}
+	}
+	for _, str := range []string{"", "yesterday", "Tue, 07 Foo 2013 11:14:33 +0000"} {
+		if _, err := ParseDate(str); err == nil {
+			t.Errorf("%q: expect error", str)
+		}
+	}
+	e := Entry{Item: Item{Date: "2013-05-07T11:14:33Z", Updated: "2013-05-08T11:14:33Z"}}
+	if !e.PublishedTime().Equal(expect) || !e.UpdatedTime().Equal(expect.AddDate(0, 0, 1)) {
+		t.Errorf("unexpected entry dates %s %s", e.PublishedTime(), e.UpdatedTime())
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// How often the entries of a feeder are delivered as digest.
+const (
+	DigestOff = iota
+	DigestDaily
+	DigestWeekly
+)
+
+var digestModes = []string{"off", "daily", "weekly"}
+
+var digestPeriods = []time.Duration{0, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// ParseDigest returns the digest mode named str.
+func ParseDigest(str string) (int, error) {
+	for mode, name := range digestModes {
+		if name == str {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("digest must be one of %s", strings.Join(digestModes, ", "))
+}
+
+// FormatDigest returns the name of digest mode.
+func FormatDigest(mode int) string {
+	if mode < 0 || mode >= len(digestModes) {
+		return "unknown"
+	}
+	return digestModes[mode]
+}
+
+// DigestDue returns whether the digest of the feeder is due at t.
+func (f *Feeder) DigestDue(t time.Time) bool {
+	if f.Digest <= DigestOff || f.Digest >= len(digestPeriods) {
+		return false
+	}
+	return f.Digested == nil || !t.Before(f.Digested.Add(digestPeriods[f.Digest]))
+}
+
+// Queue stores the entries as pending for the next digest. Entries already
+// pending are replaced with their latest version.
+func (f *Feeder) Queue(db *sql.DB, entries []Entry, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec(`insert or replace into feedpending (feeder, key, entry, time) values (?, ?, ?, ?)`,
+			f.Id, e.Key(), string(data), now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Pending returns the entries queued for the next digest in queued order.
+func (f *Feeder) Pending(db *sql.DB) ([]Entry, error) {
+	rows, err := db.Query(`select entry from feedpending where feeder=? order by id`, f.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Entry
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		err = json.Unmarshal([]byte(data), &e)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// DigestHtml returns a html digest with a table of contents and all entries.
+func DigestHtml(title string, entries []Entry) (io.Reader, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<h1>%s</h1>\n<ol>\n", html.EscapeString(title))
+	for i, e := range entries {
+		fmt.Fprintf(&buf, "<li><a href=\"#entry%d\">%s</a></li>\n", i+1, html.EscapeString(e.Title))
+	}
+	buf.WriteString("</ol>\n")
+	for i, e := range entries {
+		r, err := e.Html()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "<hr/>\n<div id=\"entry%d\">\n", i+1)
+		io.Copy(&buf, r)
+		buf.WriteString("</div>\n")
+	}
+	return &buf, nil
+}
+
+// DigestText returns a plain text digest with a table of contents and all entries.
+func DigestText(title string, entries []Entry) (io.Reader, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s\n\n", title)
+	for i, e := range entries {
+		fmt.Fprintf(&buf, "%d. %s\n", i+1, strings.Join(strings.Fields(e.Title), " "))
+	}
+	for _, e := range entries {
+		r, err := e.Text()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n----\n\n")
+		io.Copy(&buf, r)
+	}
+	return &buf, nil
+}
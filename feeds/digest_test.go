@@ -0,0 +1,97 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigest(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "news", "http://example.org/rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2013, 5, 7, 12, 0, 0, 0, time.UTC)
+	if f.DigestDue(now) {
+		t.Error("expect no digest if disabled")
+	}
+	err = SetOption(db, f.Id, "digest", DigestDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Digest = DigestDaily
+	if !f.DigestDue(now) {
+		t.Error("expect first digest due")
+	}
+	entries := []Entry{
+		{Item: Item{GUID: "1", Title: "First", Link: "http://example.org/1", Description: "one"}},
+		{Item: Item{GUID: "2", Title: "Second", Link: "http://example.org/2", Description: "two"}},
+	}
+	err = f.Queue(db, entries, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// queueing a changed entry replaces the pending version
+	changed := entries[1]
+	changed.Description = "two changed"
+	err = f.Queue(db, []Entry{changed}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := f.Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Title != "First" || pending[1].Description != "two changed" {
+		t.Fatalf("unexpected pending entries %v", pending)
+	}
+	r, err := DigestHtml("news daily digest", pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := r.(*bytes.Buffer).String()
+	if !strings.Contains(html, `<li><a href="#entry2">Second</a></li>`) || !strings.Contains(html, `<div id="entry2">`) {
+		t.Errorf("expect table of contents got %s", html)
+	}
+	r, err = DigestText("news daily digest", pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := r.(*bytes.Buffer).String(); !strings.HasPrefix(text, "# news daily digest\n\n1. First\n2. Second\n") {
+		t.Errorf("unexpected text digest %s", text)
+	}
+	f.Digested = &now
+	err = f.Fed(db, pending, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = f.Pending(db)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("expect no pending entries after fed got %v %v", pending, err)
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs[0].Digested == nil || !fs[0].Digested.Equal(now) {
+		t.Errorf("expect digested time saved got %v", fs[0].Digested)
+	}
+	if fs[0].DigestDue(now.Add(23*time.Hour)) || !fs[0].DigestDue(now.Add(24*time.Hour)) {
+		t.Error("expect next digest due after one day")
+	}
+}
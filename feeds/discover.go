@@ -0,0 +1,150 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Candidate is a feed found by Discover.
+type Candidate struct {
+	Url   string
+	Title string
+	Type  string
+}
+
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+	"application/rdf+xml":   true,
+}
+
+// CommonPaths lists paths checked if a page does not link to its feeds.
+var CommonPaths = []string{"/feed", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml", "/feed.json"}
+
+// Discover returns the feed candidates for the page at rawurl. If rawurl is a
+// feed it is the only candidate. Otherwise the alternate links of the html page
+// and, if there are none, the common feed paths of the site are returned.
+// Candidates are not validated. The page is requested with ft and its size
+// limit.
+func Discover(ft *Fetcher, rawurl string) ([]Candidate, error) {
+	resp, err := ft.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &HTTPError{rawurl, resp.StatusCode}
+	}
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ctype := resp.Header.Get("Content-Type")
+	if typ, err := Detect(ctype, buf.Bytes()); err == nil {
+		return []Candidate{{Url: rawurl, Type: typeNames[typ]}}, nil
+	}
+	// use the final url after redirects as base
+	base := resp.Request.URL
+	res, err := FindLinks(&buf, base)
+	if err != nil || len(res) > 0 {
+		return res, err
+	}
+	for _, p := range CommonPaths {
+		ref, _ := url.Parse(p)
+		res = append(res, Candidate{Url: base.ResolveReference(ref).String()})
+	}
+	return res, nil
+}
+
+// FindLinks returns the feed candidates from alternate links in the html document.
+func FindLinks(r io.Reader, base *url.URL) ([]Candidate, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var res []Candidate
+	seen := make(map[string]bool)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "link" || n.Data == "a") {
+			var c Candidate
+			var rel, href string
+			for _, a := range n.Attr {
+				switch a.Key {
+				case "rel":
+					rel = strings.ToLower(a.Val)
+				case "type":
+					c.Type, _, _ = mime.ParseMediaType(a.Val)
+				case "href":
+					href = strings.TrimSpace(a.Val)
+				case "title":
+					c.Title = a.Val
+				}
+			}
+			if feedTypes[c.Type] && href != "" && hasWord(rel, "alternate") {
+				if ref, err := url.Parse(href); err == nil {
+					c.Url = base.ResolveReference(ref).String()
+					if !seen[c.Url] {
+						seen[c.Url] = true
+						res = append(res, c)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return res, nil
+}
+
+func hasWord(list, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+var typeNames = map[int]string{
+	TypeRss:  "application/rss+xml",
+	TypeAtom: "application/atom+xml",
+	TypeRdf:  "application/rdf+xml",
+	TypeJson: "application/feed+json",
+}
+
+// Validate requests the candidate's url and checks that it is a valid feed.
+func (c *Candidate) Validate() error {
+	feed, err := ReadHttp(c.Url)
+	if err != nil {
+		return err
+	}
+	if c.Title == "" {
+		c.Title = feed.Channel.Title
+	}
+	if c.Type == "" {
+		c.Type = typeNames[feed.Type]
+	}
+	return nil
+}
+
+func (c *Candidate) String() string {
+	if c.Title == "" {
+		return fmt.Sprintf("%s (%s)", c.Url, c.Type)
+	}
+	return fmt.Sprintf("%s %q (%s)", c.Url, c.Title, c.Type)
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	var ft Fetcher
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<!DOCTYPE html><html><head>
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="Comics" href="/rss.xml">
+<link rel="alternate" type="application/atom+xml; charset=utf-8" href="atom.xml">
+<link rel="alternate" type="application/rss+xml" href="/broken.xml">
+<link rel="alternate" type="application/rss+xml" href="/rss.xml">
+</head><body></body></html>`)
+	})
+	mux.HandleFunc("/rss.xml", serveFeed)
+	mux.HandleFunc("/atom.xml", serveFeed)
+	mux.HandleFunc("/blog/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body>no feeds</body></html>`)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	cands, err := Discover(&ft, s.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{s.URL + "/rss.xml", s.URL + "/atom.xml", s.URL + "/broken.xml"}
+	if len(cands) != len(expect) {
+		t.Fatalf("expect %d candidates got %v", len(expect), cands)
+	}
+	for i, c := range cands {
+		if c.Url != expect[i] {
+			t.Errorf("expect %s got %s", expect[i], c.Url)
+		}
+	}
+	if cands[0].Title != "Comics" || cands[1].Type != "application/atom+xml" {
+		t.Errorf("unexpected candidates %v", cands)
+	}
+	if err := cands[1].Validate(); err != nil || cands[1].Title != "xkcd.com" {
+		t.Errorf("expect valid feed got %v %s", err, cands[1].Title)
+	}
+	if err := cands[2].Validate(); err == nil {
+		t.Error("expect broken candidate to be invalid")
+	}
+	cands, err = Discover(&ft, s.URL+"/rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cands) != 1 || cands[0].Url != s.URL+"/rss.xml" || cands[0].Type != "application/rss+xml" {
+		t.Errorf("expect feed url as only candidate got %v", cands)
+	}
+	cands, err = Discover(&ft, s.URL+"/blog/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cands) != len(CommonPaths) || cands[0].Url != s.URL+"/feed" {
+		t.Errorf("expect common paths got %v", cands)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// How enclosures of entries are delivered.
+const (
+	EnclosureLink = iota
+	EnclosureAttach
+	EnclosureStore
+)
+
+var enclosureModes = []string{"link", "attach", "store"}
+
+// ParseEnclosures returns the enclosure mode named str.
+func ParseEnclosures(str string) (int, error) {
+	for mode, name := range enclosureModes {
+		if name == str {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("enclosures must be one of %s", strings.Join(enclosureModes, ", "))
+}
+
+// FormatEnclosures returns the name of enclosure mode.
+func FormatEnclosures(mode int) string {
+	if mode < 0 || mode >= len(enclosureModes) {
+		return "unknown"
+	}
+	return enclosureModes[mode]
+}
+
+// MaxEnclosureSize limits the size of downloaded enclosures if the feeder has
+// no limit.
+var MaxEnclosureSize int64 = 20 << 20
+
+// Attachment is a downloaded enclosure.
+type Attachment struct {
+	Name string
+	Type string
+	Data []byte
+}
+
+// FetchEnclosure downloads the enclosure of the entry with ft if it is not
+// larger than max bytes. Zero means MaxEnclosureSize.
+func (e *Entry) FetchEnclosure(ft *Fetcher, max int64) (*Attachment, error) {
+	enc := e.Enclosure
+	if enc.URL == "" {
+		return nil, fmt.Errorf("entry has no enclosure")
+	}
+	if max <= 0 {
+		max = MaxEnclosureSize
+	}
+	if enc.Length > max {
+		return nil, fmt.Errorf("%s too large", enc.URL)
+	}
+	data, typ, err := fetchLimited(ft, enc.URL, max)
+	if err != nil {
+		return nil, err
+	}
+	if enc.Type != "" {
+		typ = enc.Type
+	}
+	if typ == "" {
+		typ = http.DetectContentType(data)
+	}
+	return &Attachment{enclosureName(enc.URL, typ), typ, data}, nil
+}
+
+// enclosureName returns a file name for the enclosure url.
+func enclosureName(rawurl, typ string) string {
+	var name string
+	if u, err := url.Parse(rawurl); err == nil {
+		name = path.Base(u.Path)
+	}
+	if name == "" || name == "." || name == ".." || name == "/" {
+		name = "enclosure"
+		if exts, _ := mime.ExtensionsByType(typ); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return name
+}
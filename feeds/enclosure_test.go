@@ -0,0 +1,66 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchEnclosure(t *testing.T) {
+	var ft Fetcher
+	mp3 := bytes.Repeat([]byte("ID3"), 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/episode/1.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(mp3)
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	e := Entry{Item: Item{Enclosure: ItemEnclosure{URL: s.URL + "/episode/1.mp3", Type: "audio/mpeg"}}}
+	att, err := e.FetchEnclosure(&ft, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.Name != "1.mp3" || att.Type != "audio/mpeg" || !bytes.Equal(att.Data, mp3) {
+		t.Errorf("unexpected attachment %s %s", att.Name, att.Type)
+	}
+	e.Enclosure = ItemEnclosure{URL: s.URL + "/download/"}
+	_, err = e.FetchEnclosure(&ft, 0)
+	if err == nil {
+		t.Error("expect error for missing enclosure")
+	}
+	e.Enclosure = ItemEnclosure{URL: s.URL + "/download"}
+	att, err = e.FetchEnclosure(&ft, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.Name != "download" || att.Type != "application/pdf" {
+		t.Errorf("unexpected attachment %s %s", att.Name, att.Type)
+	}
+	e.Enclosure.Length = MaxEnclosureSize + 1
+	if _, err = e.FetchEnclosure(&ft, 0); err == nil {
+		t.Error("expect error for announced length over limit")
+	}
+	e.Enclosure = ItemEnclosure{URL: s.URL + "/episode/1.mp3"}
+	if _, err = e.FetchEnclosure(&ft, 100); err == nil {
+		t.Error("expect error for enclosure over feeder limit")
+	}
+	max := MaxEnclosureSize
+	defer func() { MaxEnclosureSize = max }()
+	MaxEnclosureSize = 100
+	e.Enclosure = ItemEnclosure{URL: s.URL + "/episode/1.mp3"}
+	if _, err = e.FetchEnclosure(&ft, 0); err == nil {
+		t.Error("expect error for enclosure over limit")
+	}
+	if mode, err := ParseEnclosures("store"); err != nil || FormatEnclosures(mode) != "store" {
+		t.Errorf("unexpected enclosure mode %d %v", mode, err)
+	}
+}
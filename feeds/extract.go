@@ -0,0 +1,205 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// MaxPageSize limits the size of pages downloaded for content extraction.
+var MaxPageSize int64 = 4 << 20
+
+// ErrNoContent is returned if no main content could be extracted from a page.
+var ErrNoContent = errors.New("no main content found")
+
+// FetchContent downloads the entry link with ft and extracts the main content of
+// the page as the full content of the entry. Larger pages are truncated. The
+// feed credentials are not sent.
+func (e *Entry) FetchContent(ft *Fetcher) error {
+	ft = ft.public()
+	req, err := ft.Request("GET", e.Link)
+	if err != nil {
+		return err
+	}
+	resp, err := ft.do(req, MaxPageSize)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return &HTTPError{e.Link, resp.StatusCode}
+	}
+	r, err := charset.NewReader(io.LimitReader(resp.Body, MaxPageSize), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	full, err := Extract(r)
+	if err != nil {
+		return err
+	}
+	e.Full = full
+	return nil
+}
+
+var (
+	positiveRegexp = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|text|blog|story`)
+	negativeRegexp = regexp.MustCompile(`(?i)comment|meta|footer|footnote|sidebar|sponsor|share|social|related|nav|promo|banner|widget|ad-|masthead`)
+)
+
+// noiseElems are removed from a page before scoring its content.
+var noiseElems = map[string]bool{
+	"script": true, "style": true, "noscript": true, "nav": true, "header": true,
+	"footer": true, "aside": true, "form": true, "iframe": true, "button": true,
+}
+
+// Extract returns the html of the main content of the page read from r. The
+// paragraphs are scored by length and comma count and their scores added to
+// their parent elements, the best scoring element is the main content.
+func Extract(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+	removeNoise(doc)
+	scores := make(map[*html.Node]float64)
+	var order []*html.Node
+	add := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = classWeight(n)
+			order = append(order, n)
+		}
+		scores[n] += score
+	}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "p" || n.Data == "pre" || n.Data == "td") {
+			text := textContent(n)
+			if len(text) >= 25 {
+				score := 1 + float64(strings.Count(text, ",")) + float64(minInt(len(text)/100, 3))
+				add(n.Parent, score)
+				if n.Parent != nil {
+					add(n.Parent.Parent, score/2)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	var top *html.Node
+	var best float64
+	for _, n := range order {
+		score := scores[n] * (1 - linkDensity(n))
+		if top == nil || score > best {
+			top, best = n, score
+		}
+	}
+	if top == nil || len(textContent(top)) < 250 {
+		return "", ErrNoContent
+	}
+	var buf bytes.Buffer
+	for c := top.FirstChild; c != nil; c = c.NextSibling {
+		err = html.Render(&buf, c)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func removeNoise(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && noiseElems[c.Data]:
+			n.RemoveChild(c)
+		default:
+			removeNoise(c)
+		}
+		c = next
+	}
+}
+
+// classWeight returns the initial score of n based on its tag, class and id.
+func classWeight(n *html.Node) float64 {
+	var w float64
+	switch n.Data {
+	case "article", "main":
+		w += 10
+	case "body":
+		w -= 10
+	}
+	for _, key := range []string{"class", "id"} {
+		val := attr(n, key)
+		if val == "" {
+			continue
+		}
+		if negativeRegexp.MatchString(val) {
+			w -= 25
+		}
+		if positiveRegexp.MatchString(val) {
+			w += 25
+		}
+	}
+	return w
+}
+
+// textContent returns the text of n with collapsed white space.
+func textContent(n *html.Node) string {
+	var buf bytes.Buffer
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+			buf.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// linkDensity returns the ratio of link text to all text in n.
+func linkDensity(n *html.Node) float64 {
+	total := len(textContent(n))
+	if total == 0 {
+		return 0
+	}
+	var links int
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			links += len(textContent(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return float64(links) / float64(total)
+}
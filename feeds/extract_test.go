@@ -0,0 +1,71 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const articlePage = `<!DOCTYPE html><html><head><title>Post</title>
+<script>var tracking = "a, b, c, d, e, f, g, h";</script></head><body>
+<nav class="menu"><a href="/">Home</a> <a href="/about">About</a></nav>
+<div id="sidebar"><p>Subscribe to our newsletter, follow us, like us, share us, read more.</p></div>
+<article class="post"><h1>Post</h1>
+<p>The first paragraph of the article is long enough to count, with commas, clauses, and words.</p>
+<p>The second paragraph continues the story, adding details, quotes, and more text for the score.</p>
+<p>The third paragraph ends the story, so that the article has well over 250 characters of text.</p>
+</article>
+<div class="comments"><p>First comment, great post, thanks, I agree, well said, more please!</p></div>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	content, err := Extract(strings.NewReader(articlePage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(content, "<h1>Post</h1>") || strings.Count(content, "<p>") != 3 {
+		t.Errorf("unexpected content %s", content)
+	}
+	_, err = Extract(strings.NewReader(`<html><body><p>Too short.</p></body></html>`))
+	if err != ErrNoContent {
+		t.Errorf("expect no content error got %v", err)
+	}
+}
+
+func TestFetchContent(t *testing.T) {
+	var ft Fetcher
+	mux := http.NewServeMux()
+	mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, articlePage)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	e := Entry{Item: Item{Link: s.URL + "/post", Description: "teaser"}}
+	hash := e.Hash()
+	if err := e.FetchContent(&ft); err != nil {
+		t.Fatal(err)
+	}
+	if e.Hash() != hash {
+		t.Error("expect full content to not change the hash")
+	}
+	r, err := e.Html()
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := r.(*bytes.Buffer).String()
+	if strings.Contains(html, "teaser") || !strings.Contains(html, "third paragraph") {
+		t.Errorf("expect full content got %s", html)
+	}
+	e = Entry{Item: Item{Link: s.URL + "/missing", Description: "teaser"}}
+	if err := e.FetchContent(&ft); err == nil || e.Full != "" {
+		t.Errorf("expect error for missing page got %v", err)
+	}
+}
@@ -5,16 +5,51 @@
 package feeds
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"hash/fnv"
+	"net/http"
+	"strings"
 	"time"
 )
 
 const (
 	_ = iota
 	TypeRss
+	TypeAtom
+	TypeRdf
+	TypeJson
 )
 
+// How changes of already fed entries are handled.
+const (
+	UpdateIgnore = iota
+	UpdateNotify
+	UpdateReplace
+)
+
+var updateModes = []string{"ignore", "notify", "replace"}
+
+// ParseUpdates returns the update mode named str.
+func ParseUpdates(str string) (int, error) {
+	for mode, name := range updateModes {
+		if name == str {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("updates must be one of %s", strings.Join(updateModes, ", "))
+}
+
+// FormatUpdates returns the name of update mode.
+func FormatUpdates(mode int) string {
+	if mode < 0 || mode >= len(updateModes) {
+		return "unknown"
+	}
+	return updateModes[mode]
+}
+
 var CreateSql = []string{
 	`create table if not exists feeder (
 	id integer primary key autoincrement,
@@ -31,8 +66,89 @@ var CreateSql = []string{
 	time   timestamp,
 	unique (feeder, link),
 	unique (feeder, title)
+)`,
+	`create table if not exists feedfilter (
+	id integer primary key autoincrement,
+	feeder integer,
+	action integer,
+	field text,
+	pattern text
+)`,
+	`create table if not exists subscriber (
+	id integer primary key autoincrement,
+	feeder integer,
+	dest integer,
+	folder text not null default '',
+	unique (feeder, dest)
+)`,
+	`create table if not exists feedpending (
+	id integer primary key autoincrement,
+	feeder integer,
+	key text,
+	entry text,
+	time timestamp,
+	unique (feeder, key)
 )`}
 
+// MigrateSql lists columns added to the tables after their initial creation.
+var MigrateSql = []struct{ Table, Column, Def string }{
+	{"feeder", "etag", "text not null default ''"},
+	{"feeder", "modified", "text not null default ''"},
+	{"feeder", "status", "integer not null default 0"},
+	{"feeder", "checked", "timestamp"},
+	{"feeder", "interval", "integer not null default 0"},
+	{"feeder", "ttl", "integer not null default 0"},
+	{"feeder", "skiphours", "text not null default ''"},
+	{"fedentry", "key", "text"},
+	{"feeder", "updates", "integer not null default 0"},
+	{"fedentry", "hash", "text"},
+	{"fedentry", "file", "text"},
+	{"feeder", "folder", "text not null default ''"},
+	{"feeder", "enable", "integer not null default 1"},
+	{"feeder", "error", "text not null default ''"},
+	{"feeder", "failures", "integer not null default 0"},
+	{"feeder", "retry", "timestamp"},
+	{"feeder", "images", "integer not null default 0"},
+	{"feeder", "fulltext", "integer not null default 0"},
+	{"feeder", "enclosures", "integer not null default 0"},
+	{"feeder", "digest", "integer not null default 0"},
+	{"feeder", "digested", "timestamp"},
+	{"feeder", "keep", "integer not null default 0"},
+	{"feeder", "maxage", "integer not null default 0"},
+	{"feeder", "keepflagged", "integer not null default 0"},
+	{"feeder", "keepunread", "integer not null default 0"},
+	{"feeder", "useragent", "text not null default ''"},
+	{"feeder", "proxy", "text not null default ''"},
+	{"feeder", "auth", "text not null default ''"},
+	{"feeder", "headers", "text not null default ''"},
+	{"feeder", "maxbody", "integer not null default 0"},
+	{"feeder", "redirects", "integer not null default 0"},
+	{"feeder", "hub", "text not null default ''"},
+	{"feeder", "topic", "text not null default ''"},
+	{"feeder", "secret", "text not null default ''"},
+	{"feeder", "lease", "timestamp"},
+	{"feeder", "categories", "integer not null default 0"},
+	{"feeder", "maxenclosure", "integer not null default 0"},
+	{"feeder", "hubmode", "text not null default ''"},
+	{"feeder", "hubnonce", "text not null default ''"},
+	{"feeder", "hubexpiry", "timestamp"},
+	{"feeder", "catfolders", "text not null default ''"},
+}
+
+// IndexSql lists indices on migrated columns.
+var IndexSql = []string{
+	`create unique index if not exists fedentry_key on fedentry (feeder, key)`,
+}
+
+// AlterSql returns the alter table and create index statements for all migrations.
+func AlterSql() []string {
+	res := make([]string, 0, len(MigrateSql)+len(IndexSql))
+	for _, m := range MigrateSql {
+		res = append(res, fmt.Sprintf("alter table %s add column %s %s", m.Table, m.Column, m.Def))
+	}
+	return append(res, IndexSql...)
+}
+
 func Create(db *sql.DB) error {
 	for _, s := range CreateSql {
 		_, err := db.Exec(s)
@@ -40,27 +156,150 @@ func Create(db *sql.DB) error {
 			return err
 		}
 	}
+	return Migrate(db)
+}
+
+// Migrate adds missing columns to tables created by an older version.
+func Migrate(db *sql.DB) error {
+	alter := AlterSql()
+	for i, m := range MigrateSql {
+		ok, err := hasColumn(db, m.Table, m.Column)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		_, err = db.Exec(alter[i])
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range IndexSql {
+		_, err := db.Exec(s)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var found bool
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		err = rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk)
+		if err != nil {
+			return false, err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	return found, rows.Err()
+}
+
 type Feeder struct {
-	Id   int64
-	Type int
-	Name string
-	Url  string
-	Time *time.Time
+	Id       int64
+	Type     int
+	Name     string
+	Url      string
+	Time     *time.Time
+	ETag     string
+	Modified string
+	Status   int
+	// Checked is the time of the last request.
+	Checked *time.Time
+	// Interval overwrites the default check interval if not zero.
+	Interval time.Duration
+	// TTL and SkipHours are scheduling hints read from the feed.
+	TTL       time.Duration
+	SkipHours []int
+	// Updates is the update mode for changed entries.
+	Updates int
+	// Folder is the slash separated path of the feed's parent folders.
+	Folder string
+	Enable bool
+	// Error is the last error checking the feed.
+	Error string
+	// Failures counts consecutive failed checks and Retry is the time
+	// until which the feed is backed off.
+	Failures int
+	Retry    *time.Time
+	// Images enables embedding the images of entries into the mails.
+	Images bool
+	// Fulltext enables extracting the entry content from the linked page.
+	Fulltext bool
+	// Enclosures is the enclosure mode and MaxEnclosure limits the size of
+	// downloaded enclosures, zero means MaxEnclosureSize.
+	Enclosures   int
+	MaxEnclosure int64
+	// Digest is the digest mode and Digested the time of the last digest.
+	Digest   int
+	Digested *time.Time
+	// Keep and MaxAge limit the number and age of messages in the feed mailbox.
+	// Flagged and unread messages are kept if KeepFlagged and KeepUnread are set.
+	Keep        int
+	MaxAge      time.Duration
+	KeepFlagged bool
+	KeepUnread  bool
+	// Fetcher holds the http settings used to request the feed.
+	Fetcher Fetcher
+	// Moved is the new url if the last request was permanently redirected.
+	Moved string
+	// Hub and Topic are the websub hub and topic advertised by the feed.
+	// Secret signs the content pushed by the hub until the Lease expires.
+	Hub    string
+	Topic  string
+	Secret string
+	Lease  *time.Time
+	// HubMode, HubNonce and HubExpiry record the pending hub request. The hub
+	// must verify it with the nonce before it expires.
+	HubMode   string
+	HubNonce  string
+	HubExpiry *time.Time
+	// Categories is the category mode and Subfolders lists the category folders
+	// created for the feeder.
+	Categories int
+	Subfolders []string
+	// backfill holds the keys Filter found for entries fed by older versions.
+	// They are written with the next Save or Fed.
+	backfill []FedEntry
 }
 
+// FedEntry records a delivered entry. Entries fed by older versions have no key
+// and are identified by the fnv hashes of their link and title.
 type FedEntry struct {
 	Id    int64
 	Feed  int64
 	Link  uint32
 	Title uint32
-	Time  time.Time
+	Key   string
+	// Hash is the content fingerprint and File the maildir file name.
+	Hash string
+	File string
+	Time time.Time
 }
 
+var FeedersSql = `select
+	id, type, name, url, time, etag, modified, status,
+	checked, interval, ttl, skiphours, updates, folder, enable, error,
+	failures, retry, images, fulltext, enclosures, digest, digested,
+	keep, maxage, keepflagged, keepunread, useragent, proxy, auth, headers,
+	maxbody, redirects, hub, topic, secret, lease, categories, maxenclosure,
+	hubmode, hubnonce, hubexpiry, catfolders
+	from feeder %s
+`
+
 func Feeders(db *sql.DB, where string, args ...interface{}) ([]Feeder, error) {
-	rows, err := db.Query(`select * from feeder `+where, args...)
+	rows, err := db.Query(fmt.Sprintf(FeedersSql, where), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -68,10 +307,27 @@ func Feeders(db *sql.DB, where string, args ...interface{}) ([]Feeder, error) {
 	var fs []Feeder
 	for rows.Next() {
 		var f Feeder
-		err = rows.Scan(&f.Id, &f.Type, &f.Name, &f.Url, &f.Time)
+		var interval, ttl, maxage int64
+		var skip, headers, subfolders string
+		err = rows.Scan(&f.Id, &f.Type, &f.Name, &f.Url, &f.Time, &f.ETag, &f.Modified, &f.Status,
+			&f.Checked, &interval, &ttl, &skip, &f.Updates, &f.Folder, &f.Enable, &f.Error,
+			&f.Failures, &f.Retry, &f.Images, &f.Fulltext, &f.Enclosures, &f.Digest, &f.Digested,
+			&f.Keep, &maxage, &f.KeepFlagged, &f.KeepUnread, &f.Fetcher.UserAgent,
+			&f.Fetcher.Proxy, &f.Fetcher.Auth, &headers, &f.Fetcher.MaxBody, &f.Fetcher.Redirects,
+			&f.Hub, &f.Topic, &f.Secret, &f.Lease, &f.Categories, &f.MaxEnclosure,
+			&f.HubMode, &f.HubNonce, &f.HubExpiry, &subfolders)
 		if err != nil {
 			return nil, err
 		}
+		f.Fetcher.Header, err = ParseHeader(headers)
+		if err != nil {
+			return nil, err
+		}
+		f.Interval = time.Duration(interval) * time.Second
+		f.TTL = time.Duration(ttl) * time.Second
+		f.MaxAge = time.Duration(maxage) * time.Second
+		f.SkipHours = parseHours(skip)
+		f.Subfolders = strings.Fields(subfolders)
 		fs = append(fs, f)
 	}
 	return fs, nil
@@ -80,6 +336,28 @@ func UpdateFeeder(db *sql.DB, name, url string) error {
 	_, err := db.Exec(`update feeder set url=? where name=?`, url, name)
 	return err
 }
+func UpdateInterval(db *sql.DB, name string, interval time.Duration) error {
+	_, err := db.Exec(`update feeder set interval=? where name=?`, int64(interval/time.Second), name)
+	return err
+}
+
+// Options lists the feeder columns that can be changed with SetOption.
+var Options = []string{"updates", "folder", "images", "fulltext", "enclosures", "digest",
+	"keep", "maxage", "keepflagged", "keepunread", "useragent", "proxy", "auth", "headers",
+	"maxbody", "redirects", "categories", "maxenclosure"}
+
+// SetOption sets the option column of the feeder with id to val.
+func SetOption(db *sql.DB, id int64, option string, val interface{}) error {
+	var valid bool
+	for _, o := range Options {
+		valid = valid || o == option
+	}
+	if !valid {
+		return fmt.Errorf("unknown feed option %q", option)
+	}
+	_, err := db.Exec(fmt.Sprintf(`update feeder set %s=? where id=?`, option), val, id)
+	return err
+}
 func NewFeeder(db *sql.DB, name, url string) (*Feeder, error) {
 	r, err := db.Exec(`insert into feeder (type, name, url) values (?, ?, ?)`, TypeRss, name, url)
 	if err != nil {
@@ -89,72 +367,346 @@ func NewFeeder(db *sql.DB, name, url string) (*Feeder, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Feeder{id, TypeRss, name, url, nil}, nil
+	return &Feeder{Id: id, Type: TypeRss, Name: name, Url: url, Enable: true}, nil
+}
+
+// EnableFeeder enables or disables the feeder with id. Enabling resets its failures.
+func EnableFeeder(db *sql.DB, id int64, enable bool) error {
+	if !enable {
+		_, err := db.Exec(`update feeder set enable=0 where id=?`, id)
+		return err
+	}
+	_, err := db.Exec(`update feeder set enable=1, failures=0, retry=null where id=?`, id)
+	return err
+}
+
+func RenameFeeder(db *sql.DB, id int64, name string) error {
+	_, err := db.Exec(`update feeder set name=? where id=?`, name, id)
+	return err
 }
 
+// DeleteFeeder deletes the feeder with id and its fed entries and filter rules.
+func DeleteFeeder(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"fedentry", "feedfilter", "subscriber", "feedpending"} {
+		_, err = tx.Exec(fmt.Sprintf(`delete from %s where feeder=?`, table), id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec(`delete from feeder where id=?`, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Mailbox returns the maildir++ folder name of the feeder.
+func (f *Feeder) Mailbox() string {
+	if f.Folder == "" {
+		return f.Name
+	}
+	return strings.Replace(f.Folder, "/", ".", -1) + "." + f.Name
+}
+
+// Entries requests the feed and returns its entries. Requests are conditional if the
+// feeder has an etag or last modified date. Entries returns no entries and no error
+// if the feed was not modified.
 func (f *Feeder) Entries() ([]Entry, error) {
-	feed, err := ReadHttp(f.Url)
+	req, err := f.Fetcher.Request("GET", f.Url)
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
+	f.Checked = &now
+	if f.ETag != "" {
+		req.Header.Set("If-None-Match", f.ETag)
+	}
+	if f.Modified != "" {
+		req.Header.Set("If-Modified-Since", f.Modified)
+	}
+	resp, err := f.Fetcher.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	f.Status = resp.StatusCode
+	f.Moved = permanentUrl(resp)
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode >= 300 {
+		// redirects are only returned if disabled
+		return nil, &HTTPError{f.Url, resp.StatusCode}
+	}
+	feed, err := ReadType(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	f.ETag = resp.Header.Get("ETag")
+	f.Modified = resp.Header.Get("Last-Modified")
+	f.Type = feed.Type
+	f.TTL = feed.Channel.Hint()
+	f.SkipHours = feed.Channel.SkipHours
+	f.discoverHub(resp.Header, feed)
 	entries := make([]Entry, 0, len(feed.Channel.Item))
 	for _, item := range feed.Channel.Item {
-		entries = append(entries, Entry{item})
+		entries = append(entries, Entry{Item: item})
 	}
 	return entries, nil
 }
 
+// permanentUrl returns the final url of resp if it was only redirected
+// permanently or an empty string.
+func permanentUrl(resp *http.Response) string {
+	req := resp.Request
+	if req == nil || req.Response == nil {
+		return ""
+	}
+	for r := req; r.Response != nil; r = r.Response.Request {
+		switch r.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		default:
+			return ""
+		}
+	}
+	return req.URL.String()
+}
+
+// Move updates the feeder url to the moved url. It fails if another feeder
+// already uses the new url.
+func (f *Feeder) Move(db *sql.DB) error {
+	var id int64
+	err := db.QueryRow(`select id from feeder where url=?`, f.Moved).Scan(&id)
+	if err == nil && id != f.Id {
+		return fmt.Errorf("moved url %s is used by another feed", f.Moved)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = db.Exec(`update feeder set url=? where id=?`, f.Moved, f.Id)
+	if err != nil {
+		return err
+	}
+	f.Url, f.Moved = f.Moved, ""
+	return nil
+}
+
+// IsGone returns whether err reports that the feed was removed for good.
+func IsGone(err error) bool {
+	herr, ok := err.(*HTTPError)
+	return ok && herr.StatusCode == http.StatusGone
+}
+
 func hashfnv(str string) uint32 {
 	h := fnv.New32()
 	h.Write([]byte(str))
 	return h.Sum32()
 }
 
+// Key returns the hex encoded sha256 hash identifying the entry. It uses the guid
+// or atom id and falls back to the link and title if the entry has no id.
+func (e *Entry) Key() string {
+	var data string
+	if e.GUID != "" {
+		data = "id:" + e.GUID
+	} else {
+		data = "link:" + e.Link + "\ntitle:" + e.Title
+	}
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash returns the hex encoded sha256 hash of the entry's title and content.
+func (e *Entry) Hash() string {
+	h := sha256.New()
+	for _, s := range []string{e.Title, e.Content, e.Encoded, e.Description} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Filter returns the entries that were not fed yet. Entries fed by older versions
+// are matched by link or title and get their key assigned with the next Save or
+// Fed. Fed entries with changed content are returned with Prev set, unless the
+// feeder ignores updates. Filter does not write to the database.
 func (f *Feeder) Filter(db *sql.DB, entries []Entry) ([]Entry, error) {
+	f.backfill = nil
 	res := make([]Entry, 0, len(entries))
-next:
+	keys := make(map[string]bool, len(entries))
 	for i := len(entries) - 1; i >= 0; i-- {
 		e := entries[i]
-		for _, o := range res {
-			if e.Title == o.Title || e.Link == o.Link {
-				continue next
-			}
+		key := e.Key()
+		if keys[key] {
+			continue
 		}
+		keys[key] = true
 		res = append(res, e)
 	}
 	entries, res = res, make([]Entry, 0, len(res))
-	stmt, err := db.Prepare(`select 1 from fedentry where feeder=? and (link=? or title=?) limit 1`)
+	stmt, err := db.Prepare(`select id, key, hash, file from fedentry where feeder=? and (key=? or
+		key is null and (link=? or title=?)) order by key is null limit 1`)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
+	backfilled := make(map[int64]bool)
 	for i := len(entries) - 1; i >= 0; i-- {
 		e := entries[i]
+		key, hash := e.Key(), e.Hash()
 		// filter seen
-		row := stmt.QueryRow(f.Id, hashfnv(e.Link), hashfnv(e.Title))
-		if err := row.Scan(new(bool)); err == sql.ErrNoRows {
+		var id int64
+		var oldkey, oldhash, file sql.NullString
+		row := stmt.QueryRow(f.Id, key, hashfnv(e.Link), hashfnv(e.Title))
+		if err := row.Scan(&id, &oldkey, &oldhash, &file); err == sql.ErrNoRows {
 			res = append(res, e)
+			continue
 		} else if err != nil {
 			return nil, err
 		}
+		if !oldkey.Valid || !oldhash.Valid {
+			if backfilled[id] {
+				// the old entry is already claimed by another entry
+				res = append(res, e)
+				continue
+			}
+			// entries fed by older versions are assumed to be unchanged
+			backfilled[id] = true
+			f.backfill = append(f.backfill, FedEntry{Id: id, Feed: f.Id, Key: key, Hash: hash})
+		} else if oldhash.String != hash && f.Updates != UpdateIgnore {
+			e.Prev = &FedEntry{Id: id, Feed: f.Id, Key: key, Hash: oldhash.String, File: file.String}
+			res = append(res, e)
+		}
 	}
 	return res, nil
 }
 
+// NotModified returns whether the last request was answered with 304 not modified.
+func (f *Feeder) NotModified() bool {
+	return f.Status == http.StatusNotModified
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (f *Feeder) update(x execer) error {
+	_, err := x.Exec(`update feeder set
+		type=?, time=?, etag=?, modified=?, status=?, checked=?, ttl=?, skiphours=?,
+		error=?, failures=?, retry=?, digested=?, hub=?, topic=?
+		where id=?`,
+		f.Type, f.Time, f.ETag, f.Modified, f.Status, f.Checked,
+		int64(f.TTL/time.Second), formatHours(f.SkipHours),
+		f.Error, f.Failures, f.Retry, f.Digested, f.Hub, f.Topic, f.Id)
+	if err != nil {
+		return err
+	}
+	for _, e := range f.backfill {
+		_, err = x.Exec(`update or ignore fedentry set key=?, hash=? where id=?`, e.Key, e.Hash, e.Id)
+		if err != nil {
+			return err
+		}
+	}
+	f.backfill = nil
+	return nil
+}
+
+// Backoff returns the time to wait after the nth consecutive failure.
+// It starts at 15 minutes and doubles with each failure up to one day.
+func Backoff(n int) time.Duration {
+	d := 15 * time.Minute
+	for i := 1; i < n && d < 24*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 24*time.Hour {
+		d = 24 * time.Hour
+	}
+	return d
+}
+
+// Failed records the error of the last check and backs off the feeder.
+// The feeder is disabled after maxfail consecutive failures if maxfail is not zero
+// or at once if the feed is gone.
+func (f *Feeder) Failed(db *sql.DB, err error, maxfail int) error {
+	now := time.Now()
+	// count from the stored failures, the feeder may be an older copy
+	qerr := db.QueryRow(`select failures from feeder where id=?`, f.Id).Scan(&f.Failures)
+	if qerr != nil {
+		return qerr
+	}
+	retry := now.Add(Backoff(f.Failures + 1))
+	f.Error = err.Error()
+	f.Failures++
+	f.Retry = &retry
+	if f.Checked == nil {
+		f.Checked = &now
+	}
+	if maxfail > 0 && f.Failures >= maxfail || IsGone(err) {
+		f.Enable = false
+	}
+	_, err = db.Exec(`update feeder set error=?, failures=?, retry=?, enable=?, checked=?, status=?
+		where id=?`, f.Error, f.Failures, f.Retry, f.Enable, f.Checked, f.Status, f.Id)
+	return err
+}
+
+// Recovered clears the failure state of the feeder after the whole check,
+// including the delivery, succeeded.
+func (f *Feeder) Recovered(db *sql.DB) error {
+	f.Error, f.Failures, f.Retry = "", 0, nil
+	_, err := db.Exec(`update feeder set error='', failures=0, retry=null where id=?`, f.Id)
+	return err
+}
+
+// Due returns whether the feeder is not backed off at time t.
+func (f *Feeder) Due(t time.Time) bool {
+	return f.Retry == nil || !f.Retry.After(t)
+}
+
+// Count returns the number of fed entries.
+func (f *Feeder) Count(db *sql.DB) (n int, err error) {
+	err = db.QueryRow(`select count(id) from fedentry where feeder=?`, f.Id).Scan(&n)
+	return n, err
+}
+
+// Save stores the feeder's type and request state.
+func (f *Feeder) Save(db *sql.DB) error {
+	return f.update(db)
+}
+
 func (f *Feeder) Fed(db *sql.DB, entries []Entry, now time.Time) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	// rollback does nothing after commit
+	defer tx.Rollback()
 	f.Time = &now
-	_, err = tx.Exec("update feeder set time=? where id=?", f.Time, f.Id)
-	stmt, err := tx.Prepare(`insert or ignore into fedentry (feeder, link, title, time) values (?, ?, ?, ?)`)
+	err = f.update(tx)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`insert or ignore into fedentry (feeder, key, hash, file, time) values (?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 	for _, e := range entries {
-		_, err := stmt.Exec(f.Id, hashfnv(e.Link), hashfnv(e.Title), now)
+		if e.Prev != nil {
+			_, err = tx.Exec(`update fedentry set hash=?, file=?, time=? where id=?`,
+				e.Hash(), e.File, now, e.Prev.Id)
+		} else {
+			_, err = stmt.Exec(f.Id, e.Key(), e.Hash(), e.File, now)
+		}
+		if err == nil {
+			// entries delivered with a digest are no longer pending
+			_, err = tx.Exec(`delete from feedpending where feeder=? and key=?`, f.Id, e.Key())
+		}
 		if err != nil {
 			return err
 		}
@@ -6,7 +6,10 @@ package feeds
 
 import (
 	"database/sql"
+	"errors"
 	_ "github.com/mattn/go-sqlite3"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -82,13 +85,489 @@ func TestFeeder(t *testing.T) {
 	if count != 1 {
 		t.Errorf("count 2: expect 1 fedentries got %d", count)
 	}
-	var link uint32
-	row = db.QueryRow(`select link from fedentry limit 1`)
-	if err := row.Scan(&link); err != nil {
+	var key string
+	row = db.QueryRow(`select key from fedentry limit 1`)
+	if err := row.Scan(&key); err != nil {
 		t.Error(err)
 		return
 	}
-	if expect := hashfnv(original[3].Link); link != expect {
-		t.Errorf("remaining: expect %x linkhash got %x", expect, link)
+	if expect := original[3].Key(); key != expect {
+		t.Errorf("remaining: expect %s key got %s", expect, key)
+	}
+}
+
+func TestFilterKeys(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "digest", "http://example.org/digest.rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := Entry{Item: Item{Title: "Old", Link: "http://example.org/old", GUID: "old"}}
+	_, err = db.Exec(`insert into fedentry (feeder, link, title, time) values (?, ?, ?, ?)`,
+		f.Id, hashfnv(legacy.Link), hashfnv(legacy.Title), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{
+		{Item: Item{Title: "Daily Digest", Link: "http://example.org/digest", GUID: "2"}},
+		{Item: Item{Title: "Daily Digest", Link: "http://example.org/digest", GUID: "1"}},
+		{Item: Item{Title: "No Id", Link: "http://example.org/noid"}},
+		legacy,
+	}
+	res, err := f.Filter(db, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || res[0].GUID != "2" || res[1].GUID != "1" || res[2].Title != "No Id" {
+		t.Fatalf("expect 3 new entries got %v", res)
+	}
+	var key sql.NullString
+	row := db.QueryRow(`select key from fedentry where feeder=?`, f.Id)
+	if err := row.Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	if key.Valid {
+		t.Errorf("expect filter to not write the legacy key got %s", key.String)
+	}
+	err = f.Fed(db, res[1:], time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	row = db.QueryRow(`select key from fedentry where feeder=? and link is not null`, f.Id)
+	if err := row.Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	if key.String != legacy.Key() {
+		t.Errorf("expect legacy entry key %s got %s", legacy.Key(), key.String)
+	}
+	res, err = f.Filter(db, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].GUID != "2" {
+		t.Errorf("expect only the second digest got %v", res)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"xkcd"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"xkcd"`)
+		w.Header().Set("Last-Modified", "Mon, 06 May 2013 04:00:00 GMT")
+		serveFeed(w, r)
+	}))
+	defer s.Close()
+	f := &Feeder{Id: 1, Type: TypeRss, Name: "xkcd", Url: s.URL}
+	entries, err := f.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || f.NotModified() {
+		t.Errorf("expect 4 entries got %d status %d", len(entries), f.Status)
+	}
+	if f.ETag != `"xkcd"` || f.Modified != "Mon, 06 May 2013 04:00:00 GMT" {
+		t.Errorf("unexpected etag %s or modified %s", f.ETag, f.Modified)
+	}
+	// the failure state is kept until the whole check succeeded
+	retry := time.Now()
+	f.Error, f.Failures, f.Retry = "failed", 2, &retry
+	entries, err = f.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 || !f.NotModified() {
+		t.Errorf("expect not modified got %d entries status %d", len(entries), f.Status)
+	}
+	if f.Error != "failed" || f.Failures != 2 || f.Retry != &retry {
+		t.Errorf("expect kept failures got %q %d %v", f.Error, f.Failures, f.Retry)
+	}
+	if requests != 2 {
+		t.Errorf("expect 2 requests got %d", requests)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`create table feeder (
+	id integer primary key autoincrement,
+	type integer,
+	name text unique,
+	url  text unique,
+	time timestamp
+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`insert into feeder (type, name, url) values (1, 'xkcd', 'http://xkcd.com/rss.xml')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// twice to check that migrations are only applied once
+	for i := 0; i < 2; i++ {
+		err = Create(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := Feeders(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].Name != "xkcd" || fs[0].ETag != "" {
+		t.Errorf("unexpected feeders %v", fs)
+	}
+	fs[0].ETag = `"etag"`
+	err = fs[0].Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = Feeders(db, "where name=?", "xkcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].ETag != `"etag"` {
+		t.Errorf("expect saved etag got %v", fs)
+	}
+}
+
+func TestFilterUpdates(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "news", "http://example.org/news.rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Entry{Item: Item{Title: "News", GUID: "1", Description: "first"}, File: "1.mail"}
+	err = f.Fed(db, []Entry{e}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := Entry{Item: Item{Title: "News", GUID: "1", Description: "second"}}
+	res, err := f.Filter(db, []Entry{changed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Errorf("expect updates to be ignored got %v", res)
+	}
+	f.Updates = UpdateNotify
+	res, err = f.Filter(db, []Entry{e, changed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Prev == nil {
+		t.Fatalf("expect one updated entry got %v", res)
+	}
+	if prev := res[0].Prev; prev.Hash != e.Hash() || prev.File != "1.mail" {
+		t.Errorf("unexpected previous entry %v", prev)
+	}
+	res[0].File = "2.mail"
+	err = f.Fed(db, res, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = f.Filter(db, []Entry{changed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Errorf("expect no updates after fed got %v", res)
+	}
+	var count int
+	row := db.QueryRow(`select count(id) from fedentry where file='2.mail'`)
+	if err := row.Scan(&count); err != nil || count != 1 {
+		t.Errorf("expect one updated fedentry got %d %v", count, err)
+	}
+}
+
+func TestDeleteFeeder(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewFeeder(db, "a", "http://example.org/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFeeder(db, "b", "http://example.org/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*Feeder{a, b} {
+		err = f.Fed(db, []Entry{{Item: Item{GUID: "1"}}}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewRule(db, f.Id, RuleExclude, "title", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = RenameFeeder(db, b.Id, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = EnableFeeder(db, b.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DeleteFeeder(db, a.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := Feeders(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].Name != "c" || fs[0].Enable {
+		t.Fatalf("expect disabled feeder c got %v", fs)
+	}
+	if n, err := fs[0].Count(db); err != nil || n != 1 {
+		t.Errorf("expect renamed feeder to keep 1 entry got %d %v", n, err)
+	}
+	if n, err := a.Count(db); err != nil || n != 0 {
+		t.Errorf("expect deleted feeder to have no entries got %d %v", n, err)
+	}
+	rules, err := Rules(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Feeder != b.Id {
+		t.Errorf("expect one rule got %v", rules)
+	}
+	if err = SetOption(db, b.Id, "name", "x"); err == nil {
+		t.Error("expect unknown option error")
+	}
+}
+
+func TestFailed(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone fishing", http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "down", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		_, err = f.Entries()
+		herr, ok := err.(*HTTPError)
+		if !ok || herr.StatusCode != http.StatusServiceUnavailable || herr.URL != s.URL {
+			t.Fatalf("expect http error got %v", err)
+		}
+		start := time.Now()
+		err = f.Failed(db, err, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Failures != i || f.Due(start) || !f.Retry.After(start.Add(Backoff(i)-time.Second)) {
+			t.Errorf("%d: unexpected failures %d or retry %s", i, f.Failures, f.Retry)
+		}
+		if f.Enable != (i < 3) {
+			t.Errorf("%d: expect enable %v", i, i < 3)
+		}
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].Enable || fs[0].Failures != 3 || fs[0].Error == "" {
+		t.Fatalf("unexpected stored feeder %v", fs)
+	}
+	err = EnableFeeder(db, f.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || !fs[0].Enable || fs[0].Failures != 0 || fs[0].Retry != nil {
+		t.Errorf("expect reset feeder got %v", fs)
+	}
+}
+
+func TestFailedDelivery(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "undeliverable", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		// each check works on a copy loaded before the last failure
+		c := *f
+		_, err = c.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Failed(db, errors.New("maildir not writable"), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Failures != i || c.Enable != (i < 3) {
+			t.Errorf("%d: unexpected failures %d enable %v", i, c.Failures, c.Enable)
+		}
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].Enable || fs[0].Failures != 3 || fs[0].Retry == nil {
+		t.Fatalf("expect disabled feeder got %v", fs)
+	}
+	err = fs[0].Recovered(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs[0].Failures != 0 || fs[0].Error != "" || fs[0].Retry != nil {
+		t.Errorf("expect recovered feeder got %d %q %v", fs[0].Failures, fs[0].Error, fs[0].Retry)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		n      int
+		expect time.Duration
+	}{
+		{0, 15 * time.Minute},
+		{1, 15 * time.Minute},
+		{2, 30 * time.Minute},
+		{4, 2 * time.Hour},
+		{7, 16 * time.Hour},
+		{8, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := Backoff(test.n); got != test.expect {
+			t.Errorf("%d: expect %s got %s", test.n, test.expect, got)
+		}
+	}
+}
+
+func TestMoved(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", serveFeed)
+	mux.Handle("/old", http.RedirectHandler("/older", http.StatusPermanentRedirect))
+	mux.Handle("/older", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	mux.Handle("/temp", http.RedirectHandler("/feed", http.StatusFound))
+	mux.Handle("/gone", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "temp", s.URL+"/temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Entries()
+	if err != nil || f.Moved != "" {
+		t.Errorf("expect temporary redirect got %q %v", f.Moved, err)
+	}
+	f, err = NewFeeder(db, "old", s.URL+"/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Entries()
+	if err != nil || f.Moved != s.URL+"/feed" {
+		t.Fatalf("expect moved url got %q %v", f.Moved, err)
+	}
+	err = f.Move(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].Url != s.URL+"/feed" || f.Url != fs[0].Url {
+		t.Errorf("expect updated url got %v", fs)
+	}
+	f, err = NewFeeder(db, "dup", s.URL+"/older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Move(db)
+	if err == nil || f.Url != s.URL+"/older" {
+		t.Errorf("expect url conflict got %v", err)
+	}
+	f, err = NewFeeder(db, "gone", s.URL+"/gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Entries()
+	if !IsGone(err) {
+		t.Fatalf("expect gone error got %v", err)
+	}
+	err = f.Failed(db, err, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Enable {
+		t.Error("expect gone feed disabled")
 	}
 }
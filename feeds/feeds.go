@@ -2,10 +2,10 @@ package feeds
 
 import (
 	"bytes"
-	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/rtfb/go-html-transform/h5"
@@ -16,82 +16,161 @@ import (
 )
 
 func Read(r io.Reader) (*Feed, error) {
+	return ReadType(r, "")
+}
+
+// ReadType reads a feed in any of the supported formats.
+// The content type is used as a hint to detect the format.
+func ReadType(r io.Reader, contentType string) (*Feed, error) {
 	var buf bytes.Buffer
 	_, err := io.Copy(&buf, r)
 	if err != nil {
 		return nil, fmt.Errorf("reading feed: %v", err)
 	}
-	dec := xml.NewDecoder(&buf)
-	dec.CharsetReader = newReaderLabel
-	var f Feed
-	if err := dec.Decode(&f); err != nil {
-		return nil, fmt.Errorf("%v\n%s", err, buf.String())
+	typ, err := Detect(contentType, buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, excerpt(buf.Bytes()))
+	}
+	f, err := Decode(typ, buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, excerpt(buf.Bytes()))
 	}
-	if len(f.Item) > 0 && len(f.Channel.Item) == 0 {
-		f.Channel.Item = f.Item
+	return f, nil
+}
+
+// maxExcerpt limits the feed content included in error messages.
+const maxExcerpt = 512
+
+// excerpt returns the start of data for error messages.
+func excerpt(data []byte) string {
+	if len(data) <= maxExcerpt {
+		return string(data)
 	}
-	return &f, nil
+	return fmt.Sprintf("%s... (%d more bytes)", data[:maxExcerpt], len(data)-maxExcerpt)
 }
 
 func newReaderLabel(label string, in io.Reader) (io.Reader, error) {
 	enc, _ := htmlindex.Get(label)
-	if enc != nil {
+	if enc == nil {
 		return nil, fmt.Errorf("unsupported charset: %q", label)
 	}
 	return txttransform.NewReader(in, enc.NewDecoder()), nil
 }
 
-var ErrGetHTTP = fmt.Errorf("http get error")
+// HTTPError is returned for feed requests answered with an error status.
+type HTTPError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http get %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
 
+// ReadHttp requests and reads the feed at url with the default fetcher settings.
 func ReadHttp(url string) (*Feed, error) {
-	resp, err := http.Get(url)
+	var ft Fetcher
+	resp, err := ft.Get(url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return nil, ErrGetHTTP
+		return nil, &HTTPError{url, resp.StatusCode}
 	}
-	return Read(resp.Body)
+	return ReadType(resp.Body, resp.Header.Get("Content-Type"))
 }
 
 type Entry struct {
 	Item
+	// Prev is the previously fed version of an updated entry.
+	Prev *FedEntry
+	// File is the name of the maildir file the entry was delivered to.
+	File string
+	// Full is the main content extracted from the linked page.
+	Full string
+	// Stored is the local path of the downloaded enclosure.
+	Stored string
 }
 
-func renderHtml(r io.Reader, w io.Writer) error {
+// parseHtml parses a html fragment and returns the sanitized document node.
+// Relative urls are resolved against base if not nil.
+func parseHtml(r io.Reader, base *url.URL) (*html.Node, error) {
 	parts, err := h5.Partial(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	node := &html.Node{Type: html.DocumentNode}
 	for _, p := range parts {
 		node.AppendChild(p)
 	}
+	sanitize(node, base)
+	return node, nil
+}
+
+func renderHtml(r io.Reader, w io.Writer, base *url.URL) error {
+	node, err := parseHtml(r, base)
+	if err != nil {
+		return err
+	}
 	tree := h5.NewTree(node)
 	t := transform.New(&tree)
 	t.Apply(transform.TransformFunc(imgAlt), "img")
 	return h5.RenderNodes(w, []*html.Node{t.Doc()})
 }
 
+// base returns the item link as base for relative urls or nil.
+func (e *Entry) base() *url.URL {
+	u, err := url.Parse(e.Link)
+	if err != nil || !u.IsAbs() {
+		return nil
+	}
+	return u
+}
+
+// content returns the most complete html content of the entry.
+func (e *Entry) content() string {
+	if e.Full != "" {
+		return e.Full
+	} else if e.Content != "" {
+		return e.Content
+	} else if e.Encoded != "" {
+		return e.Encoded
+	}
+	return e.Description
+}
+
 func (e *Entry) Html() (io.Reader, error) {
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "<h1><a href=\"%s\">%s</a></h1>\n", e.Link, e.Title)
-	if e.Content != "" {
-		renderHtml(strings.NewReader(e.Content), &buf)
-	} else if e.Encoded != "" {
-		renderHtml(strings.NewReader(e.Encoded), &buf)
+	link, ok := cleanUrl(e.base(), e.Link)
+	if ok {
+		fmt.Fprintf(&buf, "<h1><a href=\"%s\">%s</a></h1>\n", html.EscapeString(link), html.EscapeString(e.Title))
 	} else {
-		renderHtml(strings.NewReader(e.Description), &buf)
+		fmt.Fprintf(&buf, "<h1>%s</h1>\n", html.EscapeString(e.Title))
 	}
-	fmt.Fprintf(&buf, "\n<p>Url: <a href=\"%s\">%s</a></p>", e.Link, e.Link)
+	renderHtml(strings.NewReader(e.content()), &buf, e.base())
+	writeLink(&buf, "Url", link, ok)
 	if url := e.Enclosure.URL; url != "" && url != e.Link {
-		fmt.Fprintf(&buf, "\n<p>Enclosure: <a href=\"%s\">%s</a></p>", url, url)
+		enc, ok := cleanUrl(e.base(), url)
+		writeLink(&buf, "Enclosure", enc, ok)
+	}
+	if e.Stored != "" {
+		// the path on the server is no link mail clients can open
+		fmt.Fprintf(&buf, "\n<p>Stored: %s</p>", html.EscapeString(e.Stored))
 	}
 	fmt.Fprintln(&buf)
 	return &buf, nil
 }
 
+// writeLink writes a paragraph with the label and a link to the url if ok.
+func writeLink(buf *bytes.Buffer, label, url string, ok bool) {
+	if !ok {
+		return
+	}
+	url = html.EscapeString(url)
+	fmt.Fprintf(buf, "\n<p>%s: <a href=\"%s\">%s</a></p>", label, url, url)
+}
+
 func imgAlt(n *html.Node) {
 	var alt string
 	for _, a := range n.Attr {
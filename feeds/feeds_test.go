@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -84,7 +86,7 @@ func TestContentEncoded(t *testing.T) {
 	}
 }
 func TestHtml(t *testing.T) {
-	e := Entry{Item{Title: "Title", Link: "Link", Description: "ignore", Content: "Content"}}
+	e := Entry{Item: Item{Title: "Title", Link: "Link", Description: "ignore", Content: "Content"}}
 	expect := `<h1><a href="Link">Title</a></h1>
 Content
 <p>Url: <a href="Link">Link</a></p>
@@ -98,3 +100,157 @@ Content
 		t.Fatalf("expected content %s got %s", expect, got)
 	}
 }
+
+func TestReadAtom(t *testing.T) {
+	f, err := os.Open("testdata/github.atom.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	feed, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Type != TypeAtom {
+		t.Errorf("type: expect %d got %d", TypeAtom, feed.Type)
+	}
+	if expect := "https://github.com/mb0/vmail/releases"; feed.Channel.Link != expect {
+		t.Errorf("link: expect %s got %s", expect, feed.Channel.Link)
+	}
+	if n := len(feed.Channel.Item); n != 2 {
+		t.Fatalf("items: expect 2 got %d", n)
+	}
+	first, second := feed.Entry(0), feed.Entry(1)
+	expect := Item{
+		Title:    "v0.2",
+		Link:     "https://github.com/mb0/vmail/releases/tag/v0.2",
+		Author:   "mb0",
+		PubDate:  "Wed, 08 May 2013 09:12:31 +0200",
+		Updated:  "Wed, 08 May 2013 09:12:31 +0200",
+		GUID:     "tag:github.com,2008:Repository/9876/v0.2",
+		Category: []string{"release"},
+		Content:  "<p>Adds <b>atom</b> support.</p>",
+	}
+	if fmt.Sprint(first.Item) != fmt.Sprint(expect) {
+		t.Errorf("expect %v got %v", expect, first.Item)
+	}
+	if expect := "v0.1 & more"; second.Title != expect {
+		t.Errorf("title: expect %s got %s", expect, second.Title)
+	}
+	if expect := "Wed, 01 May 2013 18:00:00 +0000"; second.PubDate != expect {
+		t.Errorf("pubdate: expect %s got %s", expect, second.PubDate)
+	}
+	if expect := "https://github.com/mb0/vmail/releases/tag/v0.1"; second.Link != expect {
+		t.Errorf("link: expect %s got %s", expect, second.Link)
+	}
+	if expect := "https://github.com/mb0/vmail/archive/v0.1.zip"; second.Enclosure.URL != expect {
+		t.Errorf("enclosure: expect %s got %s", expect, second.Enclosure.URL)
+	}
+	if expect := "First &lt;release&gt;"; second.Description != expect {
+		t.Errorf("summary: expect %s got %s", expect, second.Description)
+	}
+	if expect := `<p>Initial release.</p>`; second.Content != expect {
+		t.Errorf("content: expect %s got %s", expect, second.Content)
+	}
+}
+
+func TestText(t *testing.T) {
+	e := Entry{Item: Item{
+		Title: "Lists  and\nlinks",
+		Link:  "http://example.org/1",
+		Description: `<h2>Intro</h2><p>Read <a href="http://example.org/a">this</a>
+and <a href="http://example.org/b">that</a> or <a href="http://example.org/a">this again</a>.</p>
+<img src="x.png" alt="A picture"><ul><li>one</li><li>two<br>lines</li></ul>
+<ol><li>first</li><li>second</li></ol><blockquote><p>quoted</p><p>text</p></blockquote>
+<script>alert(1)</script>`,
+	}}
+	r, err := e.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `# Lists and links
+
+## Intro
+
+Read this [1] and that [2] or this again [1].
+
+[A picture]
+
+* one
+* two
+  lines
+
+1. first
+2. second
+
+> quoted
+>
+> text
+
+Url: http://example.org/1
+
+Links:
+[1] http://example.org/a
+[2] http://example.org/b
+`
+	if got := r.(*bytes.Buffer).String(); got != expect {
+		t.Errorf("expected text %s got %s", expect, got)
+	}
+}
+
+func TestXhtmlContent(t *testing.T) {
+	tests := []struct{ inner, expect string }{
+		{`<div xmlns="http://www.w3.org/1999/xhtml"><p>a</p></div>`, `<p>a</p>`},
+		{` <xhtml:div xmlns:xhtml="http://www.w3.org/1999/xhtml">a <xhtml:b>b</xhtml:b></xhtml:div> `, `a <xhtml:b>b</xhtml:b>`},
+		{`<div xmlns="http://www.w3.org/1999/xhtml"/>`, ``},
+		{`<p>no wrapper</p>`, `<p>no wrapper</p>`},
+	}
+	for _, test := range tests {
+		if got := xhtmlContent(test.inner); got != test.expect {
+			t.Errorf("%s: expect %q got %q", test.inner, test.expect, got)
+		}
+	}
+}
+
+func TestAtomTitle(t *testing.T) {
+	doc := `<feed xmlns="http://www.w3.org/2005/Atom"><title>Titles</title>
+<entry><id>1</id><title type="html">&lt;b&gt;Bold&lt;/b&gt; news &amp;amp; more</title></entry>
+<entry><id>2</id><title type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><b>Bold</b> news &amp; more</div></title></entry>
+<entry><id>3</id><title>Plain &lt;b&gt; text</title></entry>
+</feed>`
+	feed, err := Read(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"Bold news & more", "Bold news & more", "Plain <b> text"}
+	if len(feed.Channel.Item) != len(expect) {
+		t.Fatalf("expect %d items got %d", len(expect), len(feed.Channel.Item))
+	}
+	for i, item := range feed.Channel.Item {
+		if item.Title != expect[i] {
+			t.Errorf("expect title %q got %q", expect[i], item.Title)
+		}
+	}
+}
+
+func TestHtmlEscape(t *testing.T) {
+	e := &Entry{Item: Item{
+		Title:     `<script>alert(1)</script>`,
+		Link:      `http://example.com/"onmouseover="x`,
+		Enclosure: ItemEnclosure{URL: `javascript:alert(1)`},
+	}}
+	r, err := e.Html()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	out := string(data)
+	for _, bad := range []string{"<script>", `"onmouseover="`, "javascript:"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("unescaped %s in %s", bad, out)
+		}
+	}
+	if !strings.Contains(out, "&lt;script&gt;") {
+		t.Errorf("expect escaped title in %s", out)
+	}
+}
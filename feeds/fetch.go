@@ -0,0 +1,280 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is the http client used for all feed requests.
+var Client = &http.Client{
+	Timeout:   2 * time.Minute,
+	Transport: newTransport(http.ProxyFromEnvironment),
+}
+
+func newTransport(proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   2,
+	}
+}
+
+// DefaultUserAgent is sent with feed requests if the feeder has no user agent.
+var DefaultUserAgent = "vmail-feeds (+https://github.com/mb0/vmail)"
+
+// MaxFeedSize limits the size of feeds if the feeder has no limit.
+var MaxFeedSize int64 = 10 << 20
+
+// MaxRedirects is the number of redirects followed if the feeder has no limit.
+const MaxRedirects = 10
+
+// ErrTooLarge is returned when a response body exceeds the size limit.
+var ErrTooLarge = errors.New("response body too large")
+
+// Fetcher holds the http settings used to request a feed.
+type Fetcher struct {
+	// UserAgent replaces the DefaultUserAgent.
+	UserAgent string
+	// Proxy is the url of a http proxy. The environment is used if empty.
+	Proxy string
+	// Auth is either user:password for basic or "Bearer token" for bearer
+	// authentication. The credentials are stored in plain text.
+	Auth string
+	// Header holds extra request headers, cookies for example.
+	Header http.Header
+	// MaxBody limits the size of the response body, zero means MaxFeedSize.
+	MaxBody int64
+	// Redirects is the number of redirects to follow, zero means MaxRedirects
+	// and a negative number disables redirects.
+	Redirects int
+}
+
+// proxies caches the transports by proxy url to reuse their connections.
+var proxies = struct {
+	sync.Mutex
+	m map[string]*http.Transport
+}{m: make(map[string]*http.Transport)}
+
+func (ft *Fetcher) transport() (http.RoundTripper, error) {
+	if ft.Proxy == "" {
+		return Client.Transport, nil
+	}
+	u, err := url.Parse(ft.Proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q", ft.Proxy)
+	}
+	proxies.Lock()
+	defer proxies.Unlock()
+	t := proxies.m[ft.Proxy]
+	if t == nil {
+		t = newTransport(http.ProxyURL(u))
+		proxies.m[ft.Proxy] = t
+	}
+	return t, nil
+}
+
+func (ft *Fetcher) client() (*http.Client, error) {
+	t, err := ft.transport()
+	if err != nil {
+		return nil, err
+	}
+	max := ft.Redirects
+	if max == 0 {
+		max = MaxRedirects
+	}
+	return &http.Client{
+		Timeout:   Client.Timeout,
+		Transport: t,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if max < 0 {
+				// return the redirect response
+				return http.ErrUseLastResponse
+			}
+			if len(via) > max {
+				return fmt.Errorf("stopped after %d redirects", max)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Request returns a new request with the user agent, credentials and headers
+// of the fetcher.
+func (ft *Fetcher) Request(method, rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	ua := ft.UserAgent
+	if ua == "" {
+		ua = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", ua)
+	// brotli is not supported by the standard library
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	for k, v := range ft.Header {
+		req.Header[k] = v
+	}
+	switch auth := ft.Auth; {
+	case auth == "":
+	case strings.HasPrefix(strings.ToLower(auth), "bearer "):
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(auth[7:]))
+	case strings.Contains(auth, ":"):
+		i := strings.Index(auth, ":")
+		req.SetBasicAuth(auth[:i], auth[i+1:])
+	default:
+		return nil, fmt.Errorf("auth must be user:password or 'Bearer token'")
+	}
+	return req, nil
+}
+
+// public returns a copy of the fetcher without credentials and extra headers
+// for requests to third party hosts, like hubs, image hosts and linked pages.
+func (ft *Fetcher) public() *Fetcher {
+	c := *ft
+	c.Auth, c.Header = "", nil
+	return &c
+}
+
+// Get requests the url and returns the response.
+func (ft *Fetcher) Get(rawurl string) (*http.Response, error) {
+	req, err := ft.Request("GET", rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return ft.Do(req)
+}
+
+// Do sends the request and returns the response. The response body is decoded
+// and returns ErrTooLarge after reading more than the size limit.
+func (ft *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	return ft.do(req, ft.MaxBody)
+}
+
+// do sends the request like Do but limits the body to max bytes, zero means
+// MaxFeedSize.
+func (ft *Fetcher) do(req *http.Request, max int64) (*http.Response, error) {
+	c, err := ft.client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = resp.Body
+	enc := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if req.Method == "HEAD" || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified {
+		// there is no body to decode
+		enc = ""
+	}
+	switch enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(r)
+	case "deflate":
+		r, err = newDeflateReader(r)
+	default:
+		err = fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if max <= 0 {
+		max = MaxFeedSize
+	}
+	resp.Body = &bodyReader{&maxReader{r, max}, resp.Body}
+	return resp, nil
+}
+
+// newDeflateReader returns a reader for zlib wrapped deflate data or, as some
+// servers send it, raw deflate data.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(2)
+	if err != nil {
+		// empty or truncated body
+		return br, nil
+	}
+	if b[0]&0x0f == 8 && (uint(b[0])<<8|uint(b[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type bodyReader struct {
+	io.Reader
+	io.Closer
+}
+
+// maxReader returns ErrTooLarge if more than n bytes are read.
+type maxReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	if m.n < 0 {
+		return n + int(m.n), ErrTooLarge
+	}
+	return n, err
+}
+
+// ParseHeader parses header lines in the form 'Key: value'.
+func ParseHeader(str string) (http.Header, error) {
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(str) + "\r\n\r\n")))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("invalid header %q: %v", str, err)
+	}
+	return http.Header(h), nil
+}
+
+// FormatHeader returns the header lines sorted by key.
+func FormatHeader(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, k := range keys {
+		for _, v := range h[k] {
+			lines = append(lines, k+": "+v)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
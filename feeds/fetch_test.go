@@ -0,0 +1,228 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFetcherRequest(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		switch {
+		case r.Header.Get("User-Agent") != "test agent":
+			http.Error(w, "user agent", http.StatusBadRequest)
+		case !ok || user != "user" || pass != "se:cret":
+			http.Error(w, "auth", http.StatusUnauthorized)
+		case r.Header.Get("Cookie") != "session=abc":
+			http.Error(w, "cookie", http.StatusBadRequest)
+		case !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"):
+			http.Error(w, "encoding", http.StatusBadRequest)
+		default:
+			data, err := ioutil.ReadFile("testdata/xkcd.rss.xml")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write(data)
+			gz.Close()
+		}
+	}))
+	defer s.Close()
+	h, err := ParseHeader("Cookie: session=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Feeder{Url: s.URL, Fetcher: Fetcher{UserAgent: "test agent", Auth: "user:se:cret", Header: h}}
+	entries, err := f.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("expect 4 entries got %d", len(entries))
+	}
+	f.Fetcher.Auth = "secret"
+	_, err = f.Entries()
+	if err == nil {
+		t.Error("expect invalid auth error")
+	}
+}
+
+func TestFetcherBearer(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "auth", http.StatusUnauthorized)
+			return
+		}
+		serveFeed(w, r)
+	}))
+	defer s.Close()
+	f := &Feeder{Url: s.URL, Fetcher: Fetcher{Auth: "bearer token"}}
+	_, err := f.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetcherMaxBody(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+	f := &Feeder{Url: s.URL, Fetcher: Fetcher{MaxBody: 1024}}
+	_, err := f.Entries()
+	if err == nil || !strings.Contains(err.Error(), ErrTooLarge.Error()) {
+		t.Errorf("expect too large error got %v", err)
+	}
+	fi, err := os.Stat("testdata/xkcd.rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Fetcher.MaxBody = fi.Size()
+	_, err = f.Entries()
+	if err != nil {
+		t.Errorf("expect feed within limit got %v", err)
+	}
+}
+
+func TestFetcherRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", serveFeed)
+	mux.Handle("/old", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	f := &Feeder{Url: s.URL + "/old"}
+	_, err := f.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Fetcher.Redirects = -1
+	_, err = f.Entries()
+	if herr, ok := err.(*HTTPError); !ok || herr.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("expect redirect error got %v", err)
+	}
+}
+
+func TestFetcherDeflate(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		var wc io.WriteCloser
+		if r.URL.Path == "/raw" {
+			wc, _ = flate.NewWriter(w, flate.DefaultCompression)
+		} else {
+			wc = zlib.NewWriter(w)
+		}
+		io.WriteString(wc, "deflated")
+		wc.Close()
+	}))
+	defer s.Close()
+	var ft Fetcher
+	for _, path := range []string{"/zlib", "/raw"} {
+		resp, err := ft.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(data) != "deflated" {
+			t.Errorf("%s: unexpected body %q %v", path, data, err)
+		}
+	}
+}
+
+func TestFetcherNotModifiedGzip(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+	f := &Feeder{Url: s.URL, ETag: `"v1"`}
+	entries, err := f.Entries()
+	if err != nil || !f.NotModified() || len(entries) != 0 {
+		t.Errorf("expect not modified got %d %d entries %v", f.Status, len(entries), err)
+	}
+	var ft Fetcher
+	for _, method := range []string{"GET", "HEAD"} {
+		req, err := ft.Request(method, s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ft.Do(req)
+		if err != nil {
+			t.Errorf("%s: expect empty response got %v", method, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestReadExcerpt(t *testing.T) {
+	_, err := Read(strings.NewReader(strings.Repeat("garbage ", 1000)))
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	if len(err.Error()) > 2*maxExcerpt {
+		t.Errorf("expect bounded error got %d bytes", len(err.Error()))
+	}
+}
+
+func TestFetcherOptions(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "xkcd", "http://xkcd.com/rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := ParseHeader("Cookie: a=b\nX-Token: 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := map[string]interface{}{
+		"useragent": "agent", "auth": "user:pass", "headers": FormatHeader(h),
+		"proxy": "http://proxy:3128", "maxbody": 4096, "redirects": -1,
+	}
+	for k, v := range opts {
+		err = SetOption(db, f.Id, k, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := fs[0].Fetcher
+	if ft.UserAgent != "agent" || ft.Auth != "user:pass" || ft.Proxy != "http://proxy:3128" ||
+		ft.MaxBody != 4096 || ft.Redirects != -1 {
+		t.Errorf("unexpected fetcher %+v", ft)
+	}
+	if ft.Header.Get("Cookie") != "a=b" || ft.Header.Get("X-Token") != "1" {
+		t.Errorf("unexpected headers %v", ft.Header)
+	}
+	if FormatHeader(ft.Header) != "Cookie: a=b\nX-Token: 1" {
+		t.Errorf("unexpected formatted headers %q", FormatHeader(ft.Header))
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	_ = iota
+	RuleInclude
+	RuleExclude
+)
+
+var ruleActions = map[int]string{RuleInclude: "include", RuleExclude: "exclude"}
+
+// RuleFields lists the entry fields rules can match.
+var RuleFields = []string{"title", "link", "category", "author", "content"}
+
+// Rule includes or excludes entries whose field matches the regular expression pattern.
+type Rule struct {
+	Id      int64
+	Feeder  int64
+	Action  int
+	Field   string
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// ParseAction returns the rule action named str.
+func ParseAction(str string) (int, error) {
+	for action, name := range ruleActions {
+		if name == str {
+			return action, nil
+		}
+	}
+	return 0, fmt.Errorf("rule action must be include or exclude")
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%d %s %s ~ /%s/", r.Id, ruleActions[r.Action], r.Field, r.Pattern)
+}
+
+func (r *Rule) compile() error {
+	if _, ok := ruleActions[r.Action]; !ok {
+		return fmt.Errorf("invalid rule action %d", r.Action)
+	}
+	var valid bool
+	for _, f := range RuleFields {
+		valid = valid || f == r.Field
+	}
+	if !valid {
+		return fmt.Errorf("rule field must be one of %s", strings.Join(RuleFields, ", "))
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return err
+	}
+	r.re = re
+	return nil
+}
+
+// Match returns whether the rule's field of e matches its pattern.
+func (r *Rule) Match(e *Entry) bool {
+	if r.re == nil && r.compile() != nil {
+		return false
+	}
+	switch r.Field {
+	case "title":
+		return r.re.MatchString(e.Title)
+	case "link":
+		return r.re.MatchString(e.Link)
+	case "category":
+		for _, c := range e.Category {
+			if r.re.MatchString(c) {
+				return true
+			}
+		}
+		return false
+	case "author":
+		return r.re.MatchString(e.Author) || r.re.MatchString(e.Creator)
+	case "content":
+		return r.re.MatchString(e.Content) || r.re.MatchString(e.Encoded) ||
+			r.re.MatchString(e.Description)
+	}
+	return false
+}
+
+// Check returns whether e passes the rules and if not the reason why.
+// Entries matching any exclude rule are dropped. If there are include rules
+// entries must match at least one of them.
+func Check(rules []Rule, e *Entry) (bool, string) {
+	var include, included bool
+	for i := range rules {
+		r := &rules[i]
+		switch r.Action {
+		case RuleExclude:
+			if r.Match(e) {
+				return false, "excluded by rule " + r.String()
+			}
+		case RuleInclude:
+			include = true
+			included = included || r.Match(e)
+		}
+	}
+	if include && !included {
+		return false, "matches no include rule"
+	}
+	return true, ""
+}
+
+// Apply returns the entries passing the rules.
+func Apply(rules []Rule, entries []Entry) []Entry {
+	if len(rules) == 0 {
+		return entries
+	}
+	res := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if ok, _ := Check(rules, &e); ok {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+var RulesSql = `select
+	id, feeder, action, field, pattern
+	from feedfilter %s order by feeder, id
+`
+
+func Rules(db *sql.DB, where string, args ...interface{}) ([]Rule, error) {
+	rows, err := db.Query(fmt.Sprintf(RulesSql, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Rule
+	for rows.Next() {
+		var r Rule
+		err = rows.Scan(&r.Id, &r.Feeder, &r.Action, &r.Field, &r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+func NewRule(db *sql.DB, feeder int64, action int, field, pattern string) (*Rule, error) {
+	r := &Rule{Feeder: feeder, Action: action, Field: field, Pattern: pattern}
+	err := r.compile()
+	if err != nil {
+		return nil, err
+	}
+	res, err := db.Exec(`insert into feedfilter (feeder, action, field, pattern) values (?, ?, ?, ?)`,
+		feeder, action, field, pattern)
+	if err != nil {
+		return nil, err
+	}
+	r.Id, err = res.LastInsertId()
+	return r, err
+}
+
+func DeleteRule(db *sql.DB, id int64) error {
+	_, err := db.Exec(`delete from feedfilter where id=?`, id)
+	return err
+}
+
+// Rules returns the filter rules of the feeder.
+func (f *Feeder) Rules(db *sql.DB) ([]Rule, error) {
+	return Rules(db, "where feeder=?", f.Id)
+}
@@ -0,0 +1,87 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	entries := []Entry{
+		{Item: Item{Title: "Wahl", Link: "http://www.tagesschau.de/inland/wahl.html", Category: []string{"Inland"}}},
+		{Item: Item{Title: "Fussball", Link: "http://www.sportschau.de/fussball.html", Category: []string{"Sport"}}},
+		{Item: Item{Title: "Tennis", Link: "http://www.tagesschau.de/tennis.html", Creator: "sportschau"}},
+	}
+	tests := []struct {
+		rules  []Rule
+		expect []bool
+	}{
+		{nil, []bool{true, true, true}},
+		{[]Rule{{Action: RuleExclude, Field: "link", Pattern: `sportschau\.de`}}, []bool{true, false, true}},
+		{[]Rule{{Action: RuleExclude, Field: "author", Pattern: `^sport`}}, []bool{true, true, false}},
+		{[]Rule{{Action: RuleInclude, Field: "category", Pattern: `^(In|Aus)land$`}}, []bool{true, false, false}},
+		{[]Rule{
+			{Action: RuleInclude, Field: "title", Pattern: `(?i)^(wahl|fussball)`},
+			{Action: RuleExclude, Field: "category", Pattern: `Sport`},
+		}, []bool{true, false, false}},
+	}
+	for i, test := range tests {
+		for j, e := range entries {
+			ok, reason := Check(test.rules, &e)
+			if ok != test.expect[j] {
+				t.Errorf("test %d entry %s: expect %v got %v %s", i, e.Title, test.expect[j], ok, reason)
+			}
+			if !ok && reason == "" {
+				t.Errorf("test %d entry %s: expect reason", i, e.Title)
+			}
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewRule(db, 1, RuleExclude, "body", "x"); err == nil {
+		t.Error("expect invalid field error")
+	}
+	if _, err = NewRule(db, 1, RuleExclude, "title", "("); err == nil {
+		t.Error("expect invalid pattern error")
+	}
+	r, err := NewRule(db, 1, RuleExclude, "title", "^Sport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRule(db, 2, RuleInclude, "link", "xkcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Feeder{Id: 1}
+	rules, err := f.Rules(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Id != r.Id || rules[0].Pattern != "^Sport" {
+		t.Errorf("unexpected rules %v", rules)
+	}
+	err = DeleteRule(db, r.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err = Rules(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Feeder != 2 {
+		t.Errorf("unexpected rules %v", rules)
+	}
+}
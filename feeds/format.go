@@ -0,0 +1,83 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+)
+
+// Detect returns the feed type of data. The content type is only used to
+// recognize json feeds, xml feeds are detected by their document element.
+func Detect(contentType string, data []byte) (int, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "application/feed+json", "application/json":
+		return TypeJson, nil
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, utf8BOM))
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return TypeJson, nil
+	}
+	root, err := rootName(data)
+	if err != nil {
+		return 0, err
+	}
+	switch root.Local {
+	case "rss":
+		return TypeRss, nil
+	case "RDF":
+		return TypeRdf, nil
+	case "feed":
+		return TypeAtom, nil
+	}
+	return 0, fmt.Errorf("unknown feed format %q", root.Local)
+}
+
+// utf8BOM is the byte order mark some servers put before json feeds.
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// Decode decodes data as feed of type typ.
+func Decode(typ int, data []byte) (*Feed, error) {
+	if typ == TypeJson {
+		return decodeJson(bytes.TrimPrefix(data, utf8BOM))
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = newReaderLabel
+	switch typ {
+	case TypeRss:
+		return decodeRss(dec)
+	case TypeRdf:
+		return decodeRdf(dec)
+	case TypeAtom:
+		return decodeAtom(dec)
+	}
+	return nil, fmt.Errorf("unknown feed type %d", typ)
+}
+
+// rootName returns the name of the document element.
+func rootName(data []byte) (xml.Name, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = newReaderLabel
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return xml.Name{}, err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name, nil
+		}
+	}
+}
+
+func decodeRss(dec *xml.Decoder) (*Feed, error) {
+	f := &Feed{Type: TypeRss}
+	if err := dec.Decode(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		file, ctype string
+		typ         int
+	}{
+		{"testdata/xkcd.rss.xml", "text/xml", TypeRss},
+		{"testdata/tagesschau.rss.xml", "", TypeRss},
+		{"testdata/github.atom.xml", "application/atom+xml; charset=utf-8", TypeAtom},
+		{"testdata/slashdot.rdf.xml", "application/rdf+xml", TypeRdf},
+		{"testdata/daringfireball.json", "application/feed+json", TypeJson},
+		{"testdata/daringfireball.json", "text/plain", TypeJson},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ, err := Detect(test.ctype, data)
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		if typ != test.typ {
+			t.Errorf("%s: expect type %d got %d", test.file, test.typ, typ)
+		}
+	}
+	if _, err := Detect("text/html", []byte("<html><body></body></html>")); err == nil {
+		t.Error("expected error for html document")
+	}
+}
+
+func TestDecodeJsonBOM(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/daringfireball.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte("\xEF\xBB\xBF"), data...)
+	typ, err := Detect("", data)
+	if err != nil || typ != TypeJson {
+		t.Fatalf("expect json feed got %d %v", typ, err)
+	}
+	feed, err := Decode(typ, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Channel.Item) == 0 {
+		t.Error("expect items")
+	}
+}
+
+func decodeFile(t *testing.T, name string) *Feed {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ, err := Detect("", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := Decode(typ, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+func TestDecodeRdf(t *testing.T) {
+	feed := decodeFile(t, "testdata/slashdot.rdf.xml")
+	if feed.Type != TypeRdf || feed.Channel.Title != "Slashdot" {
+		t.Errorf("unexpected channel %d %s", feed.Type, feed.Channel.Title)
+	}
+	if n := len(feed.Channel.Item); n != 1 {
+		t.Fatalf("items: expect 1 got %d", n)
+	}
+	it := feed.Channel.Item[0]
+	if expect := "München Switches Back"; it.Title != expect {
+		t.Errorf("title: expect %s got %s", expect, it.Title)
+	}
+	if expect := "Straße"; it.Description != expect {
+		t.Errorf("description: expect %s got %s", expect, it.Description)
+	}
+	if expect := "http://slashdot.org/story/13/05/07/1"; it.GUID != expect || it.Link != expect {
+		t.Errorf("guid and link: expect %s got %s %s", expect, it.GUID, it.Link)
+	}
+	if expect := "Tue, 07 May 2013 11:14:33 +0000"; it.PubDate != expect {
+		t.Errorf("pubdate: expect %s got %s", expect, it.PubDate)
+	}
+	if len(it.Category) != 1 || it.Category[0] != "linux" {
+		t.Errorf("category: expect [linux] got %v", it.Category)
+	}
+}
+
+func TestDecodeJson(t *testing.T) {
+	feed := decodeFile(t, "testdata/daringfireball.json")
+	if feed.Type != TypeJson || feed.Channel.Link != "https://daringfireball.net/" {
+		t.Errorf("unexpected channel %d %s", feed.Type, feed.Channel.Link)
+	}
+	if n := len(feed.Channel.Item); n != 2 {
+		t.Fatalf("items: expect 2 got %d", n)
+	}
+	first, second := feed.Channel.Item[0], feed.Channel.Item[1]
+	if expect := "Tue, 07 May 2013 11:14:33 -0400"; first.PubDate != expect {
+		t.Errorf("pubdate: expect %s got %s", expect, first.PubDate)
+	}
+	if first.Content != "<p>Apple news.</p>" || first.Enclosure.Type != "audio/mpeg" {
+		t.Errorf("unexpected item %v", first)
+	}
+	if second.GUID != "42" || second.Link != "https://example.org/" {
+		t.Errorf("unexpected id or link %s %s", second.GUID, second.Link)
+	}
+	if expect := "Mon, 06 May 2013 10:00:00 +0000"; second.PubDate != expect {
+		t.Errorf("pubdate: expect %s got %s", expect, second.PubDate)
+	}
+	if expect := "<p>plain &lt;text&gt;<br/>\nsecond line</p>"; second.Content != expect {
+		t.Errorf("content: expect %s got %s", expect, second.Content)
+	}
+}
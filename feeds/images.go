@@ -0,0 +1,127 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rtfb/go-html-transform/h5"
+	"golang.org/x/net/html"
+)
+
+// Image is a downloaded image to be embedded as inline part of a feed mail.
+type Image struct {
+	Cid  string
+	Type string
+	Data []byte
+}
+
+// Limits for the images embedded into a single entry.
+var (
+	MaxImages          = 20
+	MaxImageSize int64 = 1 << 20
+)
+
+// EmbedImages downloads the images referenced by the html read from r with ft
+// and rewrites their src to cid urls. Images that cannot be downloaded or exceed
+// the limits keep their remote url.
+func EmbedImages(ft *Fetcher, r io.Reader) (io.Reader, []Image, error) {
+	parts, err := h5.Partial(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var imgs []Image
+	cids := make(map[string]string)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "img" {
+			for i, a := range n.Attr {
+				if a.Key != "src" {
+					continue
+				}
+				cid, ok := cids[a.Val]
+				if !ok && len(imgs) < MaxImages {
+					img, err := fetchImage(ft, a.Val)
+					if err == nil {
+						cid = img.Cid
+						imgs = append(imgs, *img)
+					}
+					cids[a.Val] = cid
+				}
+				if cid != "" {
+					n.Attr[i].Val = "cid:" + cid
+				}
+				break
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, p := range parts {
+		walk(p)
+	}
+	var buf bytes.Buffer
+	err = h5.RenderNodes(&buf, parts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &buf, imgs, nil
+}
+
+func fetchImage(ft *Fetcher, url string) (*Image, error) {
+	data, typ, err := fetchLimited(ft, url, MaxImageSize)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(typ, "image/") {
+		typ = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(typ, "image/") {
+		return nil, fmt.Errorf("%s is no image", url)
+	}
+	sum := sha256.Sum256([]byte(url))
+	return &Image{hex.EncodeToString(sum[:8]) + "@feeds", typ, data}, nil
+}
+
+// fetchLimited downloads the http url with ft and returns the data and content
+// type. It returns an error if the response body is larger than max bytes. The
+// feed credentials are not sent.
+func fetchLimited(ft *Fetcher, url string, max int64) ([]byte, string, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, "", fmt.Errorf("url %q not supported", url)
+	}
+	ft = ft.public()
+	req, err := ft.Request("GET", url)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := ft.do(req, max)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, "", &HTTPError{url, resp.StatusCode}
+	}
+	if resp.ContentLength > max {
+		return nil, "", fmt.Errorf("%s too large", url)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == ErrTooLarge {
+		return nil, "", fmt.Errorf("%s too large", url)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
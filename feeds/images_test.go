@@ -0,0 +1,65 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmbedImages(t *testing.T) {
+	ft := Fetcher{Auth: "user:secret", Header: http.Header{"Cookie": {"session=abc"}}}
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a.gif", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+			t.Errorf("expect no feed credentials sent to image host got %v", r.Header)
+		}
+		w.Write(gif)
+	})
+	mux.HandleFunc("/large.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte{0}, int(MaxImageSize)+1))
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	in := `<p><img src="` + s.URL + `/a.gif"><img src="` + s.URL + `/large.png">` +
+		`<img src="` + s.URL + `/page.html"><img src="` + s.URL + `/a.gif"></p>`
+	r, imgs, err := EmbedImages(&ft, strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 {
+		t.Fatalf("expect one image got %d", len(imgs))
+	}
+	if imgs[0].Type != "image/gif" || !bytes.Equal(imgs[0].Data, gif) {
+		t.Errorf("unexpected image %s %v", imgs[0].Type, imgs[0].Data)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid := `src="cid:` + imgs[0].Cid + `"`
+	if strings.Count(string(out), cid) != 2 {
+		t.Errorf("expect both references rewritten got %s", out)
+	}
+	if !strings.Contains(string(out), s.URL+"/large.png") || !strings.Contains(string(out), s.URL+"/page.html") {
+		t.Errorf("expect failed images to keep their url got %s", out)
+	}
+	max := MaxImages
+	defer func() { MaxImages = max }()
+	MaxImages = 0
+	_, imgs, err = EmbedImages(&ft, strings.NewReader(in))
+	if err != nil || len(imgs) != 0 {
+		t.Errorf("expect no images over limit got %d %v", len(imgs), err)
+	}
+}
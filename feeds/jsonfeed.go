@@ -0,0 +1,127 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// JSON Feed 1.1 as specified in https://jsonfeed.org/version/1.1
+
+type JsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url"`
+	FeedURL     string     `json:"feed_url"`
+	Description string     `json:"description"`
+	Language    string     `json:"language"`
+	Hubs        []JsonHub  `json:"hubs"`
+	Items       []JsonItem `json:"items"`
+}
+
+type JsonHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type JsonItem struct {
+	Id            json.RawMessage  `json:"id"`
+	URL           string           `json:"url"`
+	ExternalURL   string           `json:"external_url"`
+	Title         string           `json:"title"`
+	ContentHtml   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Tags          []string         `json:"tags"`
+	Author        *JsonAuthor      `json:"author"`
+	Authors       []JsonAuthor     `json:"authors"`
+	Attachments   []JsonAttachment `json:"attachments"`
+}
+
+type JsonAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type JsonAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size_in_bytes"`
+}
+
+// id returns the item id. Some publishers use numbers instead of strings.
+func (it *JsonItem) id() string {
+	var s string
+	if json.Unmarshal(it.Id, &s) == nil {
+		return s
+	}
+	return string(it.Id)
+}
+
+// Feed normalizes the json feed to a rss feed.
+func (j *JsonFeed) Feed() *Feed {
+	f := &Feed{Type: TypeJson}
+	f.Channel.Title = j.Title
+	f.Channel.Link = j.HomePageURL
+	f.Channel.Description = j.Description
+	f.Channel.Language = j.Language
+	for _, h := range j.Hubs {
+		if strings.EqualFold(h.Type, "websub") {
+			f.Channel.AtomLink = append(f.Channel.AtomLink, AtomLink{Href: h.URL, Rel: "hub"})
+		}
+	}
+	if j.FeedURL != "" {
+		f.Channel.AtomLink = append(f.Channel.AtomLink, AtomLink{Href: j.FeedURL, Rel: "self"})
+	}
+	f.Channel.Item = make([]Item, 0, len(j.Items))
+	for _, it := range j.Items {
+		item := Item{
+			Title:       it.Title,
+			Link:        it.URL,
+			GUID:        it.id(),
+			PubDate:     atomDate(it.DatePublished),
+			Updated:     atomDate(it.DateModified),
+			Category:    it.Tags,
+			Description: html.EscapeString(it.Summary),
+			Content:     it.ContentHtml,
+		}
+		if len(it.Authors) > 0 {
+			item.Author = it.Authors[0].Name
+		} else if it.Author != nil {
+			item.Author = it.Author.Name
+		}
+		if item.Link == "" {
+			item.Link = it.ExternalURL
+		}
+		if item.Content == "" && it.ContentText != "" {
+			item.Content = "<p>" + strings.Replace(html.EscapeString(it.ContentText), "\n", "<br/>\n", -1) + "</p>"
+		}
+		if item.PubDate == "" {
+			item.PubDate = item.Updated
+		}
+		if len(it.Attachments) > 0 {
+			a := it.Attachments[0]
+			item.Enclosure = ItemEnclosure{URL: a.URL, Type: a.MimeType, Length: a.Size}
+		}
+		f.Channel.Item = append(f.Channel.Item, item)
+	}
+	return f
+}
+
+func decodeJson(data []byte) (*Feed, error) {
+	var j JsonFeed
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(j.Version, "https://jsonfeed.org/version/") {
+		return nil, fmt.Errorf("unknown json feed version %q", j.Version)
+	}
+	return j.Feed(), nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// OPML 2.0 as specified in http://dev.opml.org/spec2.html
+
+type Opml struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"head>title"`
+	Created string    `xml:"head>dateCreated,omitempty"`
+	Outline []Outline `xml:"body>outline"`
+}
+
+type Outline struct {
+	Text    string    `xml:"text,attr"`
+	Title   string    `xml:"title,attr,omitempty"`
+	Type    string    `xml:"type,attr,omitempty"`
+	XmlUrl  string    `xml:"xmlUrl,attr,omitempty"`
+	HtmlUrl string    `xml:"htmlUrl,attr,omitempty"`
+	Outline []Outline `xml:"outline"`
+}
+
+// Subscription is a feed found in an opml document. Folder contains the
+// slash separated names of the parent outlines.
+type Subscription struct {
+	Name   string
+	Url    string
+	Folder string
+}
+
+// CleanName returns str as name usable for feeders and folders. Names are used in
+// email addresses and maildir folders and restricted to lower case letters, digits,
+// dashes and underscores.
+func CleanName(str string) string {
+	str = strings.ToLower(strings.TrimSpace(str))
+	b := make([]byte, 0, len(str))
+	dash := false
+	for _, r := range str {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b = append(b, byte(r))
+			dash = false
+		case !dash && len(b) > 0:
+			b = append(b, '-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(string(b), "-")
+}
+
+// ReadOpml returns the subscriptions of the opml document read from r.
+func ReadOpml(r io.Reader) ([]Subscription, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = newReaderLabel
+	var o Opml
+	err := dec.Decode(&o)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions(nil, o.Outline, nil), nil
+}
+
+func subscriptions(res []Subscription, outlines []Outline, folder []string) []Subscription {
+	for _, o := range outlines {
+		name := o.Text
+		if name == "" {
+			name = o.Title
+		}
+		if o.XmlUrl != "" {
+			res = append(res, Subscription{CleanName(name), o.XmlUrl, strings.Join(folder, "/")})
+		}
+		if len(o.Outline) > 0 {
+			sub := folder
+			if o.XmlUrl == "" && CleanName(name) != "" {
+				sub = append(folder[:len(folder):len(folder)], CleanName(name))
+			}
+			res = subscriptions(res, o.Outline, sub)
+		}
+	}
+	return res
+}
+
+// WriteOpml writes an opml document with the feeders nested in their folders.
+func WriteOpml(w io.Writer, title string, fs []Feeder) error {
+	o := Opml{Version: "2.0", Title: title, Created: time.Now().Format(time.RFC1123Z)}
+	for _, f := range fs {
+		list := &o.Outline
+		if f.Folder != "" {
+			for _, name := range strings.Split(f.Folder, "/") {
+				list = folderOutline(list, name)
+			}
+		}
+		*list = append(*list, Outline{Text: f.Name, Title: f.Name, Type: "rss", XmlUrl: f.Url})
+	}
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = enc.Encode(o)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func folderOutline(list *[]Outline, name string) *[]Outline {
+	for i, o := range *list {
+		if o.XmlUrl == "" && o.Text == name {
+			return &(*list)[i].Outline
+		}
+	}
+	*list = append(*list, Outline{Text: name})
+	return &(*list)[len(*list)-1].Outline
+}
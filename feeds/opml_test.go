@@ -0,0 +1,62 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCleanName(t *testing.T) {
+	tests := []struct{ str, expect string }{
+		{"xkcd", "xkcd"},
+		{" Tech & Science ", "tech-science"},
+		{"tagesschau.de - Die Nachrichten der ARD", "tagesschau-de-die-nachrichten-der-ard"},
+		{"Über_alles!", "ber_alles"},
+	}
+	for _, test := range tests {
+		if got := CleanName(test.str); got != test.expect {
+			t.Errorf("%q: expect %q got %q", test.str, test.expect, got)
+		}
+	}
+}
+
+func TestOpml(t *testing.T) {
+	f, err := os.Open("testdata/subscriptions.opml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	subs, err := ReadOpml(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Subscription{
+		{"xkcd", "http://xkcd.com/rss.xml", ""},
+		{"tagesschau-de-die-nachrichten-der-ard", "http://www.tagesschau.de/xml/rss2", "news"},
+		{"slashdot", "http://rss.slashdot.org/Slashdot/slashdot", "news/tech-science"},
+	}
+	if fmt.Sprint(subs) != fmt.Sprint(expect) {
+		t.Fatalf("expect %v got %v", expect, subs)
+	}
+	fs := make([]Feeder, 0, len(subs))
+	for _, s := range subs {
+		fs = append(fs, Feeder{Name: s.Name, Url: s.Url, Folder: s.Folder})
+	}
+	var buf bytes.Buffer
+	err = WriteOpml(&buf, "vmail feeds", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ReadOpml(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(again) != fmt.Sprint(expect) {
+		t.Errorf("roundtrip: expect %v got %v", expect, again)
+	}
+}
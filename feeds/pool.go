@@ -0,0 +1,133 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Pool limits the number of concurrent feed checks overall and per host.
+type Pool struct {
+	global  chan struct{}
+	perHost int
+	mu      sync.Mutex
+	hosts   map[string]chan struct{}
+}
+
+// NewPool returns a pool running at most workers checks and at most perHost
+// checks of feeds on the same host at a time.
+func NewPool(workers, perHost int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+	return &Pool{
+		global:  make(chan struct{}, workers),
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+func (p *Pool) host(rawurl string) chan struct{} {
+	host := rawurl
+	if u, err := url.Parse(rawurl); err == nil && u.Host != "" {
+		host = strings.ToLower(u.Hostname())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.hosts[host]
+	if c == nil {
+		c = make(chan struct{}, p.perHost)
+		p.hosts[host] = c
+	}
+	return c
+}
+
+// Acquire blocks until a check of the feed at rawurl may start or quit is
+// closed. It returns the release function or nil if quit was closed.
+func (p *Pool) Acquire(rawurl string, quit <-chan struct{}) func() {
+	host := p.host(rawurl)
+	select {
+	case host <- struct{}{}:
+	case <-quit:
+		return nil
+	}
+	select {
+	case p.global <- struct{}{}:
+	case <-quit:
+		<-host
+		return nil
+	}
+	return func() {
+		<-p.global
+		<-host
+	}
+}
+
+// Run calls check for all feeders within the pool limits and waits for all
+// checks to finish. It returns the errors by feeder index.
+func (p *Pool) Run(fs []Feeder, check func(Feeder) error) []error {
+	errs := make([]error, len(fs))
+	var wg sync.WaitGroup
+	for i := range fs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release := p.Acquire(fs[i].Url, nil)
+			defer release()
+			errs[i] = check(fs[i])
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Writer serializes database writes of concurrent feed checks, because sqlite
+// only supports one writer at a time.
+type Writer struct {
+	db   *sql.DB
+	reqs chan writeReq
+	done chan struct{}
+}
+
+type writeReq struct {
+	fn  func(*sql.DB) error
+	res chan error
+}
+
+// NewWriter starts a writer for db.
+func NewWriter(db *sql.DB) *Writer {
+	w := &Writer{db, make(chan writeReq), make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		for req := range w.reqs {
+			req.res <- req.fn(w.db)
+		}
+	}()
+	return w
+}
+
+// DB returns the database for concurrent reads.
+func (w *Writer) DB() *sql.DB {
+	return w.db
+}
+
+// Do calls fn with the database after all previous writes finished.
+func (w *Writer) Do(fn func(*sql.DB) error) error {
+	res := make(chan error, 1)
+	w.reqs <- writeReq{fn, res}
+	return <-res
+}
+
+// Close stops the writer after all pending writes finished.
+func (w *Writer) Close() {
+	close(w.reqs)
+	<-w.done
+}
@@ -0,0 +1,98 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolLimits(t *testing.T) {
+	var fs []Feeder
+	for i := 0; i < 12; i++ {
+		host := "http://a.example.com/"
+		if i%2 == 1 {
+			host = "http://B.example.com:8080/"
+		}
+		fs = append(fs, Feeder{Id: int64(i), Url: host + "feed"})
+	}
+	var mu sync.Mutex
+	var total, maxTotal int
+	hosts := make(map[string]int)
+	maxHost := 0
+	pool := NewPool(3, 2)
+	errs := pool.Run(fs, func(f Feeder) error {
+		host := f.Url[:12]
+		mu.Lock()
+		total++
+		hosts[host]++
+		if total > maxTotal {
+			maxTotal = total
+		}
+		if hosts[host] > maxHost {
+			maxHost = hosts[host]
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		total--
+		hosts[host]--
+		mu.Unlock()
+		return nil
+	})
+	if len(errs) != len(fs) {
+		t.Fatalf("expected %d results got %d", len(fs), len(errs))
+	}
+	if maxTotal > 3 {
+		t.Errorf("expected at most 3 concurrent checks got %d", maxTotal)
+	}
+	if maxHost > 2 {
+		t.Errorf("expected at most 2 concurrent checks per host got %d", maxHost)
+	}
+}
+
+func TestPoolAcquireQuit(t *testing.T) {
+	pool := NewPool(1, 1)
+	release := pool.Acquire("http://example.com/a", nil)
+	quit := make(chan struct{})
+	close(quit)
+	if r := pool.Acquire("http://example.com/b", quit); r != nil {
+		t.Error("expected no release after quit")
+	}
+	release()
+	if r := pool.Acquire("http://other.com/", nil); r == nil {
+		t.Error("expected release")
+	} else {
+		r()
+	}
+}
+
+func TestWriterSerializes(t *testing.T) {
+	w := NewWriter(nil)
+	var running, max int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Do(func(*sql.DB) error {
+				running++
+				if running > max {
+					max = running
+				}
+				time.Sleep(time.Millisecond)
+				running--
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	w.Close()
+	if max != 1 {
+		t.Errorf("expected serialized writes got %d concurrent", max)
+	}
+}
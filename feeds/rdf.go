@@ -0,0 +1,53 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import "encoding/xml"
+
+// RSS 1.0 as specified in http://web.resource.org/rss/1.0/spec
+// items are siblings of the channel and use the dublin core module for dates.
+
+type RdfFeed struct {
+	Channel Channel   `xml:"channel"`
+	Item    []RdfItem `xml:"item"`
+}
+
+type RdfItem struct {
+	About       string   `xml:"about,attr"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Encoded     string   `xml:"encoded"`
+	Date        string   `xml:"date"`
+	Creator     string   `xml:"creator"`
+	Subject     []string `xml:"subject"`
+}
+
+// Feed normalizes the rdf feed to a rss feed.
+func (r *RdfFeed) Feed() *Feed {
+	f := &Feed{Type: TypeRdf, Channel: r.Channel}
+	f.Channel.Item = make([]Item, 0, len(r.Item))
+	for _, it := range r.Item {
+		f.Channel.Item = append(f.Channel.Item, Item{
+			Title:       it.Title,
+			Link:        it.Link,
+			Creator:     it.Creator,
+			GUID:        it.About,
+			PubDate:     atomDate(it.Date),
+			Category:    it.Subject,
+			Description: it.Description,
+			Encoded:     it.Encoded,
+		})
+	}
+	return f
+}
+
+func decodeRdf(dec *xml.Decoder) (*Feed, error) {
+	var r RdfFeed
+	if err := dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	return r.Feed(), nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"sort"
+	"time"
+)
+
+// Message is a delivered mail in a feed maildir.
+type Message struct {
+	Path    string
+	Time    time.Time
+	Seen    bool
+	Flagged bool
+}
+
+// Retains returns whether the feeder has a retention policy.
+func (f *Feeder) Retains() bool {
+	return f.Keep > 0 || f.MaxAge > 0
+}
+
+// Expire returns the messages to remove from the feed maildir at time now.
+// Messages exceeding the keep count or older than the max age expire, unless
+// they are flagged or unread and the feeder keeps those.
+func (f *Feeder) Expire(msgs []Message, now time.Time) []Message {
+	if !f.Retains() {
+		return nil
+	}
+	sorted := make([]Message, len(msgs))
+	copy(sorted, msgs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+	var res []Message
+	for i, m := range sorted {
+		if f.KeepFlagged && m.Flagged || f.KeepUnread && !m.Seen {
+			continue
+		}
+		if f.Keep > 0 && i >= f.Keep || f.MaxAge > 0 && now.Sub(m.Time) > f.MaxAge {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// PruneLimit returns the number of fed entries to keep for a feed with n
+// entries. Entries must be remembered longer than the mails are kept and while
+// they are in the feed, otherwise expired entries would be delivered again.
+func (f *Feeder) PruneLimit(n int) int {
+	limit := 256
+	if f.Keep > 0 && f.Keep+n > limit {
+		limit = f.Keep + n
+	}
+	if 2*n > limit {
+		limit = 2 * n
+	}
+	return limit
+}
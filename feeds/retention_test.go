@@ -0,0 +1,57 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	now := time.Date(2013, 5, 7, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	msgs := []Message{
+		{Path: "old", Time: now.Add(-10 * day), Seen: true},
+		{Path: "new", Time: now, Seen: true},
+		{Path: "flagged", Time: now.Add(-9 * day), Seen: true, Flagged: true},
+		{Path: "unread", Time: now.Add(-8 * day)},
+		{Path: "recent", Time: now.Add(-day), Seen: true},
+	}
+	tests := []struct {
+		f      Feeder
+		expect []string
+	}{
+		{Feeder{}, nil},
+		{Feeder{Keep: 2}, []string{"unread", "flagged", "old"}},
+		{Feeder{MaxAge: 7 * day}, []string{"unread", "flagged", "old"}},
+		{Feeder{MaxAge: 7 * day, KeepFlagged: true}, []string{"unread", "old"}},
+		{Feeder{Keep: 1, KeepFlagged: true, KeepUnread: true}, []string{"recent", "old"}},
+		{Feeder{Keep: 4, MaxAge: 9*day + time.Hour}, []string{"old"}},
+	}
+	for _, test := range tests {
+		got := test.f.Expire(msgs, now)
+		if len(got) != len(test.expect) {
+			t.Errorf("%+v: expect %v got %v", test.f, test.expect, got)
+			continue
+		}
+		for i, m := range got {
+			if m.Path != test.expect[i] {
+				t.Errorf("%+v: expect %v got %v", test.f, test.expect, got)
+				break
+			}
+		}
+	}
+	f := Feeder{}
+	if l := f.PruneLimit(30); l != 256 {
+		t.Errorf("expect default prune limit got %d", l)
+	}
+	f.Keep = 1000
+	if l := f.PruneLimit(30); l != 1030 {
+		t.Errorf("expect prune limit above keep got %d", l)
+	}
+	if l := (&Feeder{}).PruneLimit(200); l != 400 {
+		t.Errorf("expect prune limit twice the feed got %d", l)
+	}
+}
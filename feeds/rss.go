@@ -7,33 +7,46 @@ package feeds
 // originates from github.com/ungerik/go-rss which is under public domain
 
 type Feed struct {
+	Type    int     `xml:"-"`
 	Channel Channel `xml:"channel"`
-	Item    []Item  `xml:"item"`
 }
 
 func (f *Feed) Entry(at int) *Entry {
-	return &Entry{f.Channel.Item[at]}
+	return &Entry{Item: f.Channel.Item[at]}
 }
 
 type Channel struct {
-	Title         string `xml:"title"`
-	Link          string `xml:"link"`
-	Description   string `xml:"description"`
-	Language      string `xml:"language"`
-	LastBuildDate string `xml:"lastBuildDate"`
-	Item          []Item `xml:"item"`
+	Title string `xml:"title"`
+	// AtomLink holds atom links like the websub hub. It must precede Link,
+	// otherwise atom links would be decoded as channel link.
+	AtomLink      []AtomLink `xml:"http://www.w3.org/2005/Atom link"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	Language      string     `xml:"language"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	TTL           int        `xml:"ttl"`
+	SkipHours     []int      `xml:"skipHours>hour"`
+	// syndication module hints
+	UpdatePeriod    string `xml:"updatePeriod"`
+	UpdateFrequency int    `xml:"updateFrequency"`
+	Item            []Item `xml:"item"`
 }
 
 type ItemEnclosure struct {
-	URL  string `xml:"url,attr"`
-	Type string `xml:"type,attr"`
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
 }
 
 type Item struct {
 	Title       string        `xml:"title"`
 	Link        string        `xml:"link"`
+	Author      string        `xml:"author"`
+	Creator     string        `xml:"creator"`
 	Comments    string        `xml:"comments"`
 	PubDate     string        `xml:"pubDate"`
+	Date        string        `xml:"date"`
+	Updated     string        `xml:"updated"`
 	GUID        string        `xml:"guid"`
 	Category    []string      `xml:"category"`
 	Enclosure   ItemEnclosure `xml:"enclosure"`
@@ -0,0 +1,148 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// dropElems are removed from feed content with all their children.
+var dropElems = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"applet": true, "form": true, "input": true, "button": true, "select": true,
+	"textarea": true, "noscript": true, "frame": true, "frameset": true, "link": true,
+	"meta": true, "base": true, "svg": true, "math": true, "template": true,
+	"head": true, "title": true,
+}
+
+// allowElems maps the elements kept in feed content to their allowed attributes.
+// Other elements are replaced by their children.
+var allowElems = map[string][]string{
+	"a": {"href"}, "img": {"src", "alt", "width", "height"},
+	"blockquote": {"cite"}, "q": {"cite"}, "del": {"cite"}, "ins": {"cite"},
+	"ol": {"start"}, "td": {"colspan", "rowspan"}, "th": {"colspan", "rowspan"},
+	"time": {"datetime"},
+	"abbr": nil, "b": nil, "br": nil, "caption": nil, "cite": nil, "code": nil,
+	"dd": nil, "details": nil, "dfn": nil, "div": nil, "dl": nil, "dt": nil,
+	"em": nil, "figcaption": nil, "figure": nil, "h1": nil, "h2": nil, "h3": nil,
+	"h4": nil, "h5": nil, "h6": nil, "hr": nil, "i": nil, "kbd": nil, "li": nil,
+	"mark": nil, "p": nil, "pre": nil, "s": nil, "samp": nil, "small": nil,
+	"span": nil, "strong": nil, "sub": nil, "summary": nil, "sup": nil,
+	"table": nil, "tbody": nil, "tfoot": nil, "thead": nil, "tr": nil, "u": nil,
+	"ul": nil, "var": nil,
+}
+
+// globalAttrs are allowed on all elements.
+var globalAttrs = []string{"title", "lang", "dir"}
+
+// urlAttrs are resolved against the base url and checked for allowed schemes.
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+// sanitize removes disallowed elements and attributes and tracking images from
+// the children of n and resolves urls against base.
+func sanitize(n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.ElementNode:
+			sanitizeElem(c, base)
+		case html.CommentNode:
+			n.RemoveChild(c)
+		default:
+			sanitize(c, base)
+		}
+		c = next
+	}
+}
+
+func sanitizeElem(n *html.Node, base *url.URL) {
+	if dropElems[n.Data] {
+		n.Parent.RemoveChild(n)
+		return
+	}
+	sanitize(n, base)
+	allow, ok := allowElems[n.Data]
+	if !ok {
+		// unwrap the element
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+			n.Parent.InsertBefore(c, n)
+		}
+		n.Parent.RemoveChild(n)
+		return
+	}
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Namespace != "" || !hasAttr(allow, a.Key) && !hasAttr(globalAttrs, a.Key) {
+			continue
+		}
+		if urlAttrs[a.Key] {
+			val, ok := cleanUrl(base, a.Val)
+			if !ok {
+				continue
+			}
+			a.Val = val
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+	if n.Data == "img" && (attr(n, "src") == "" || isPixel(n)) {
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" && !isPixel(n) {
+			// keep the alt text of images without usable source
+			n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: "[" + alt + "]"}, n)
+		}
+		n.Parent.RemoveChild(n)
+	}
+}
+
+func hasAttr(list []string, key string) bool {
+	for _, k := range list {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isPixel returns whether the image n is a tracking pixel.
+func isPixel(n *html.Node) bool {
+	w, h := attr(n, "width"), attr(n, "height")
+	return (w == "0" || w == "1") && (h == "0" || h == "1")
+}
+
+// cleanUrl resolves raw against base and removes utm_* query parameters.
+// It returns false for urls with unsupported schemes.
+func cleanUrl(base *url.URL, raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		q := u.Query()
+		var strip bool
+		for k := range q {
+			if strings.HasPrefix(k, "utm_") {
+				delete(q, k)
+				strip = true
+			}
+		}
+		if strip {
+			u.RawQuery = q.Encode()
+		}
+	case "mailto", "cid":
+	case "":
+		// keep relative urls if there is no base
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
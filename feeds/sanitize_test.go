@@ -0,0 +1,40 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	base, _ := url.Parse("http://example.org/posts/1")
+	tests := []struct{ in, expect string }{
+		{`<p onclick="evil()" style="color:red" class="x">text</p>`, `<p>text</p>`},
+		{`<script>alert(1)</script><style>p{}</style><iframe src="/x"></iframe>ok`, `ok`},
+		{`<font color="red"><b>bold</b></font><!-- comment -->`, `<b>bold</b>`},
+		{`<a href="javascript:evil()" title="t">link</a>`, `<a title="t">link</a>`},
+		{`<a href="../2?utm_source=feed&amp;id=2&amp;utm_medium=rss">next</a>`, `<a href="http://example.org/2?id=2">next</a>`},
+		{`<a href="http://other.org/?utm_campaign=x">other</a>`, `<a href="http://other.org/">other</a>`},
+		{`<a href="#top">top</a> <a href="mailto:me@example.org">me</a>`,
+			`<a href="http://example.org/posts/1#top">top</a> <a href="mailto:me@example.org">me</a>`},
+		{`<img src="/img.png" width="100" data-x="y"><img src="http://t.example/p.gif" width="1" height="1">`,
+			`<img src="http://example.org/img.png" width="100"/>`},
+		{`<img src="data:image/png;base64,AAAA"><img alt="no source">`, `[no source]`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := renderHtml(strings.NewReader(test.in), &buf, base)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := buf.String(); got != test.expect {
+			t.Errorf("for %s expect %s got %s", test.in, test.expect, got)
+		}
+	}
+}
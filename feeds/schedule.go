@@ -0,0 +1,88 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var updatePeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// Hint returns the minimal check interval the channel asks for using the rss ttl
+// element or the syndication module's update period and frequency.
+func (c *Channel) Hint() time.Duration {
+	d := time.Duration(c.TTL) * time.Minute
+	if period, ok := updatePeriods[strings.TrimSpace(c.UpdatePeriod)]; ok {
+		freq := c.UpdateFrequency
+		if freq < 1 {
+			freq = 1
+		}
+		if sy := period / time.Duration(freq); sy > d {
+			d = sy
+		}
+	}
+	return d
+}
+
+// Next returns the time the feeder should be checked next. It uses the feeder's
+// interval or def if it has none, unless the feed hints at a longer interval.
+// Hours the feed asks to skip are skipped. A feeder never checked is due immediately.
+// Failed feeders are checked after their backoff.
+func (f *Feeder) Next(def time.Duration) time.Time {
+	if f.Retry != nil {
+		return *f.Retry
+	}
+	if f.Checked == nil {
+		return time.Time{}
+	}
+	d := f.Interval
+	if d <= 0 {
+		d = def
+	}
+	if f.TTL > d {
+		d = f.TTL
+	}
+	next := f.Checked.Add(d)
+	for i := 0; i < 24 && f.skipHour(next.UTC().Hour()); i++ {
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+	return next
+}
+
+func (f *Feeder) skipHour(hour int) bool {
+	for _, h := range f.SkipHours {
+		if h == hour {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHours(str string) []int {
+	var res []int
+	for _, s := range strings.Split(str, ",") {
+		h, err := strconv.Atoi(strings.TrimSpace(s))
+		if err == nil && h >= 0 && h < 24 {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+func formatHours(hours []int) string {
+	strs := make([]string, 0, len(hours))
+	for _, h := range hours {
+		strs = append(strs, strconv.Itoa(h))
+	}
+	return strings.Join(strs, ",")
+}
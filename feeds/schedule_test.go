@@ -0,0 +1,60 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHint(t *testing.T) {
+	tests := []struct {
+		c      Channel
+		expect time.Duration
+	}{
+		{Channel{}, 0},
+		{Channel{TTL: 10}, 10 * time.Minute},
+		{Channel{UpdatePeriod: "hourly", UpdateFrequency: 2}, 30 * time.Minute},
+		{Channel{UpdatePeriod: "daily"}, 24 * time.Hour},
+		{Channel{TTL: 120, UpdatePeriod: "hourly"}, 2 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := test.c.Hint(); got != test.expect {
+			t.Errorf("%v: expect %s got %s", test.c, test.expect, got)
+		}
+	}
+	f, err := os.Open("testdata/tagesschau.rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	feed, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := feed.Channel.Hint(); got != 10*time.Minute {
+		t.Errorf("tagesschau: expect 10m got %s", got)
+	}
+}
+
+func TestNext(t *testing.T) {
+	checked := time.Date(2013, 5, 7, 10, 15, 0, 0, time.UTC)
+	tests := []struct {
+		f      Feeder
+		expect time.Time
+	}{
+		{Feeder{}, time.Time{}},
+		{Feeder{Checked: &checked}, checked.Add(time.Hour)},
+		{Feeder{Checked: &checked, Interval: 10 * time.Minute}, checked.Add(10 * time.Minute)},
+		{Feeder{Checked: &checked, Interval: 10 * time.Minute, TTL: 2 * time.Hour}, checked.Add(2 * time.Hour)},
+		{Feeder{Checked: &checked, SkipHours: []int{11, 12}}, time.Date(2013, 5, 7, 13, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := test.f.Next(time.Hour); !got.Equal(test.expect) {
+			t.Errorf("%v: expect %s got %s", test.f, test.expect, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Subscriber links a feeder to the mailbox of a store.Dest. The entries of
+// subscribed feeders are delivered into the subscriber's own maildir.
+type Subscriber struct {
+	Id     int64
+	Feeder int64
+	Dest   int64
+	// Folder is the slash separated path of the feed's parent folders in the
+	// subscriber's maildir.
+	Folder string
+}
+
+// Mailbox returns the maildir++ folder name for the feeder named name.
+func (s *Subscriber) Mailbox(name string) string {
+	if s.Folder == "" {
+		return name
+	}
+	return strings.Replace(s.Folder, "/", ".", -1) + "." + name
+}
+
+var SubscribersSql = `select
+	id, feeder, dest, folder
+	from subscriber %s
+`
+
+func Subscribers(db *sql.DB, where string, args ...interface{}) ([]Subscriber, error) {
+	rows, err := db.Query(fmt.Sprintf(SubscribersSql, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Subscriber
+	for rows.Next() {
+		var s Subscriber
+		err = rows.Scan(&s.Id, &s.Feeder, &s.Dest, &s.Folder)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// Subscribe subscribes the dest to the feeder or changes the folder of an
+// existing subscription.
+func Subscribe(db *sql.DB, feeder, dest int64, folder string) error {
+	_, err := db.Exec(`insert or replace into subscriber (feeder, dest, folder) values (?, ?, ?)`,
+		feeder, dest, folder)
+	return err
+}
+
+// Unsubscribe removes the subscription of dest to feeder.
+func Unsubscribe(db *sql.DB, feeder, dest int64) error {
+	r, err := db.Exec(`delete from subscriber where feeder=? and dest=?`, feeder, dest)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no subscription found")
+	}
+	return nil
+}
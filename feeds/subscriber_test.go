@@ -0,0 +1,67 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestSubscribers(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFeeder(db, "xkcd", "http://xkcd.com/rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Subscribe(db, f.Id, 1, "Feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Subscribe(db, f.Id, 2, "Feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// changes the folder of the existing subscription
+	err = Subscribe(db, f.Id, 1, "Feeds/Comics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, err := Subscribers(db, "where feeder=? order by dest", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 || subs[0].Dest != 1 || subs[1].Dest != 2 {
+		t.Fatalf("unexpected subscribers %v", subs)
+	}
+	if mb := subs[0].Mailbox(f.Name); mb != "Feeds.Comics.xkcd" {
+		t.Errorf("expect mailbox Feeds.Comics.xkcd got %s", mb)
+	}
+	if mb := (&Subscriber{}).Mailbox(f.Name); mb != "xkcd" {
+		t.Errorf("expect mailbox xkcd got %s", mb)
+	}
+	err = Unsubscribe(db, f.Id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Unsubscribe(db, f.Id, 2); err == nil {
+		t.Error("expect error for missing subscription")
+	}
+	err = DeleteFeeder(db, f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, err = Subscribers(db, "")
+	if err != nil || len(subs) != 0 {
+		t.Errorf("expect subscribers deleted got %v %v", subs, err)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Text returns a plain text rendering of the entry. Links are listed as
+// numbered footnotes below the content.
+func (e *Entry) Text() (io.Reader, error) {
+	t := &textRenderer{}
+	t.heading(1, e.Title)
+	err := t.renderHtml(strings.NewReader(e.content()), e.base())
+	if err != nil {
+		return nil, err
+	}
+	t.block()
+	fmt.Fprintf(&t.buf, "Url: %s\n", e.Link)
+	if url := e.Enclosure.URL; url != "" && url != e.Link {
+		fmt.Fprintf(&t.buf, "Enclosure: %s\n", url)
+	}
+	if e.Stored != "" {
+		fmt.Fprintf(&t.buf, "Stored: %s\n", e.Stored)
+	}
+	if len(t.links) > 0 {
+		t.buf.WriteString("\nLinks:\n")
+		for i, l := range t.links {
+			fmt.Fprintf(&t.buf, "[%d] %s\n", i+1, l)
+		}
+	}
+	return &t.buf, nil
+}
+
+type textRenderer struct {
+	buf   bytes.Buffer
+	links []string
+	pre   bool
+}
+
+func (t *textRenderer) renderHtml(r io.Reader, base *url.URL) error {
+	node, err := parseHtml(r, base)
+	if err != nil {
+		return err
+	}
+	t.node(node)
+	return nil
+}
+
+// newlines ensures the text ends with n line breaks unless it is empty.
+func (t *textRenderer) newlines(n int) {
+	b := bytes.TrimRight(t.buf.Bytes(), " ")
+	t.buf.Truncate(len(b))
+	if len(b) == 0 {
+		return
+	}
+	for i := len(b) - 1; i >= 0 && b[i] == '\n' && n > 0; i-- {
+		n--
+	}
+	for ; n > 0; n-- {
+		t.buf.WriteByte('\n')
+	}
+}
+
+func (t *textRenderer) line()  { t.newlines(1) }
+func (t *textRenderer) block() { t.newlines(2) }
+
+func (t *textRenderer) atLineStart() bool {
+	b := t.buf.Bytes()
+	return len(b) == 0 || b[len(b)-1] == '\n' || b[len(b)-1] == ' '
+}
+
+func (t *textRenderer) text(s string) {
+	if t.pre {
+		t.buf.WriteString(s)
+		return
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" && !t.atLineStart() {
+			t.buf.WriteByte(' ')
+		}
+		return
+	}
+	if isSpace(s[0]) && !t.atLineStart() {
+		t.buf.WriteByte(' ')
+	}
+	t.buf.WriteString(strings.Join(fields, " "))
+	if isSpace(s[len(s)-1]) {
+		t.buf.WriteByte(' ')
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func (t *textRenderer) heading(level int, s string) {
+	t.block()
+	t.buf.WriteString(strings.Repeat("#", level))
+	t.buf.WriteByte(' ')
+	t.buf.WriteString(strings.Join(strings.Fields(s), " "))
+	t.block()
+}
+
+// link returns the footnote number of url.
+func (t *textRenderer) link(url string) int {
+	for i, l := range t.links {
+		if l == url {
+			return i + 1
+		}
+	}
+	t.links = append(t.links, url)
+	return len(t.links)
+}
+
+func (t *textRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		t.node(c)
+	}
+}
+
+func (t *textRenderer) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		t.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		t.children(n)
+		return
+	}
+	switch n.Data {
+	case "script", "style", "head", "title":
+	case "br":
+		t.buf.WriteByte('\n')
+	case "hr":
+		t.block()
+		t.buf.WriteString("----")
+		t.block()
+	case "img":
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			t.text("[" + alt + "]")
+		}
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		var sub textRenderer
+		sub.links = t.links
+		sub.children(n)
+		t.links = sub.links
+		t.heading(int(n.Data[1]-'0'), sub.buf.String())
+	case "a":
+		t.children(n)
+		href := attr(n, "href")
+		if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "mailto:") {
+			if !t.atLineStart() {
+				t.buf.WriteByte(' ')
+			}
+			fmt.Fprintf(&t.buf, "[%d]", t.link(href))
+		}
+	case "ul", "ol":
+		t.block()
+		for i, c := 1, n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data != "li" {
+				t.node(c)
+				continue
+			}
+			mark := "* "
+			if n.Data == "ol" {
+				mark = strconv.Itoa(i) + ". "
+				i++
+			}
+			t.indented(c, mark, strings.Repeat(" ", len(mark)), false)
+		}
+		t.block()
+	case "blockquote":
+		t.block()
+		t.indented(n, "> ", "> ", true)
+		t.block()
+	case "pre":
+		t.block()
+		t.pre = true
+		t.children(n)
+		t.pre = false
+		t.block()
+	case "p", "div", "table", "dl", "figure", "section", "article":
+		t.block()
+		t.children(n)
+		t.block()
+	case "li", "tr", "dt", "dd", "figcaption":
+		t.line()
+		t.children(n)
+		t.line()
+	default:
+		t.children(n)
+	}
+}
+
+// indented renders the children of n on new lines prefixed with first for the
+// first and prefix for all following lines. Empty lines are only prefixed if
+// blank is true.
+func (t *textRenderer) indented(n *html.Node, first, prefix string, blank bool) {
+	var sub textRenderer
+	sub.links = t.links
+	sub.children(n)
+	t.links = sub.links
+	lines := strings.Split(strings.Trim(sub.buf.String(), "\n "), "\n")
+	t.line()
+	for i, l := range lines {
+		switch {
+		case i == 0:
+			t.buf.WriteString(first)
+		case l != "":
+			t.buf.WriteString(prefix)
+		case blank:
+			t.buf.WriteString(strings.TrimRight(prefix, " "))
+		}
+		t.buf.WriteString(l)
+		t.buf.WriteByte('\n')
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,325 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebSub as specified in https://www.w3.org/TR/websub/
+
+// LeaseSeconds is the lease duration requested from hubs.
+var LeaseSeconds = 7 * 24 * 3600
+
+// RenewBefore is the time before a lease expires when it is renewed.
+const RenewBefore = 24 * time.Hour
+
+// PushInterval is the minimum polling interval of feeds with an active lease.
+const PushInterval = 12 * time.Hour
+
+// VerifyTimeout is the time the hub has to verify a request.
+const VerifyTimeout = time.Hour
+
+// Hub returns the hub and self links of the channel.
+func (c *Channel) Hub() (hub, self string) {
+	if l := atomLink(c.AtomLink, "hub"); l != nil {
+		hub = l.Href
+	}
+	if l := atomLink(c.AtomLink, "self"); l != nil {
+		self = l.Href
+	}
+	return hub, self
+}
+
+// headerLink returns the url of the first link header with rel.
+func headerLink(h http.Header, rel string) string {
+	for _, v := range h["Link"] {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			ref := strings.TrimSpace(parts[0])
+			if len(ref) < 2 || ref[0] != '<' || ref[len(ref)-1] != '>' {
+				continue
+			}
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if !strings.HasPrefix(p, "rel=") {
+					continue
+				}
+				for _, r := range strings.Fields(strings.Trim(p[4:], `"`)) {
+					if strings.EqualFold(r, rel) {
+						return ref[1 : len(ref)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// discoverHub sets the hub and topic of the feeder from the response header or
+// the feed. The topic defaults to the feed url.
+func (f *Feeder) discoverHub(h http.Header, feed *Feed) {
+	hub, self := headerLink(h, "hub"), headerLink(h, "self")
+	if hub == "" {
+		hub, self = feed.Channel.Hub()
+	}
+	if hub != "" && self == "" {
+		self = f.Url
+	}
+	f.Hub, f.Topic = hub, self
+}
+
+// Leased returns whether the feeder has a push subscription at t.
+func (f *Feeder) Leased(t time.Time) bool {
+	return f.Secret != "" && f.Lease != nil && f.Lease.After(t)
+}
+
+// NeedsLease returns whether the feeder advertises a hub and has no push
+// subscription or one expiring soon after t.
+func (f *Feeder) NeedsLease(t time.Time) bool {
+	return f.Enable && f.Hub != "" && f.Topic != "" && !f.Leased(t.Add(RenewBefore))
+}
+
+// SaveHub stores the secret, lease and pending hub request of the feeder.
+func (f *Feeder) SaveHub(db *sql.DB) error {
+	_, err := db.Exec(`update feeder set secret=?, lease=?, hubmode=?, hubnonce=?, hubexpiry=?
+		where id=?`, f.Secret, f.Lease, f.HubMode, f.HubNonce, f.HubExpiry, f.Id)
+	return err
+}
+
+// NewHubRequest records a pending hub request with mode and a new nonce that
+// expires after the VerifyTimeout. It must be saved before it is sent.
+func (f *Feeder) NewHubRequest(mode string, now time.Time) error {
+	nonce, err := NewSecret()
+	if err != nil {
+		return err
+	}
+	expiry := now.Add(VerifyTimeout)
+	f.HubMode, f.HubNonce, f.HubExpiry = mode, nonce, &expiry
+	return nil
+}
+
+// pending returns whether the hub verifies the pending request with mode and
+// nonce at t.
+func (f *Feeder) pending(mode, nonce string, t time.Time) bool {
+	return f.HubMode != "" && f.HubMode == mode && f.HubExpiry != nil && t.Before(*f.HubExpiry) &&
+		hmac.Equal([]byte(f.HubNonce), []byte(nonce))
+}
+
+// NewSecret returns a random secret for signing pushed content.
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CallbackUrl returns the callback url for feeder id below the base url.
+func CallbackUrl(base string, id int64) string {
+	return strings.TrimRight(base, "/") + "/" + strconv.FormatInt(id, 10)
+}
+
+// HubRequest sends the pending request of the feeder to subscribe or unsubscribe
+// the callback url to its hub. The hub verifies the request by calling the
+// callback with the nonce of the request.
+func (f *Feeder) HubRequest(callback string) error {
+	if f.HubMode == "" || f.HubNonce == "" {
+		return fmt.Errorf("no pending hub request")
+	}
+	sep := "?"
+	if strings.Contains(callback, "?") {
+		sep = "&"
+	}
+	form := url.Values{
+		"hub.mode":     {f.HubMode},
+		"hub.topic":    {f.Topic},
+		"hub.callback": {callback + sep + "nonce=" + f.HubNonce},
+	}
+	if f.HubMode == "subscribe" {
+		form.Set("hub.secret", f.Secret)
+		form.Set("hub.lease_seconds", strconv.Itoa(LeaseSeconds))
+	}
+	ft := f.Fetcher.public()
+	req, err := ft.Request("POST", f.Hub)
+	if err != nil {
+		return err
+	}
+	body := form.Encode()
+	req.Body = ioutil.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := ft.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &HTTPError{f.Hub, resp.StatusCode}
+	}
+	return nil
+}
+
+// CheckSignature returns whether sig is a valid X-Hub-Signature of body.
+func CheckSignature(secret string, body []byte, sig string) bool {
+	i := strings.Index(sig, "=")
+	if i < 0 {
+		return false
+	}
+	var h func() hash.Hash
+	switch sig[:i] {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+	want, err := hex.DecodeString(sig[i+1:])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// Callback handles the websub callback requests for all feeders. The feeder id
+// is the last element of the request path.
+type Callback struct {
+	// Feeder returns the feeder with id or nil.
+	Feeder func(id int64) (*Feeder, error)
+	// Verified stores the lease of feeder f after the hub verified its pending
+	// request.
+	Verified func(f *Feeder) error
+	// Push delivers the entries pushed for feeder f. It should return quickly,
+	// hubs treat slow answers as failed deliveries.
+	Push func(f *Feeder, entries []Entry) error
+}
+
+func (c *Callback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := c.Feeder(id)
+	if err != nil {
+		log.Printf("websub callback %d: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		c.verify(w, r, f)
+	case "POST":
+		c.push(w, r, f)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers the intent verification of the hub with the challenge if it
+// matches the pending request of the feeder.
+func (c *Callback) verify(w http.ResponseWriter, r *http.Request, f *Feeder) {
+	q := r.URL.Query()
+	mode := q.Get("hub.mode")
+	now := time.Now()
+	pending := f != nil && q.Get("hub.topic") == f.Topic && f.pending(mode, q.Get("nonce"), now)
+	switch mode {
+	case "subscribe":
+		secs, err := strconv.Atoi(q.Get("hub.lease_seconds"))
+		if !pending || !f.Enable || f.Secret == "" || err != nil || secs <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if secs > LeaseSeconds {
+			secs = LeaseSeconds
+		}
+		lease := now.Add(time.Duration(secs) * time.Second)
+		f.Lease = &lease
+	case "unsubscribe":
+		if !pending {
+			http.NotFound(w, r)
+			return
+		}
+		f.Lease = nil
+	case "denied":
+		if f != nil {
+			log.Printf("websub %s: hub denied subscription: %s", f.Name, q.Get("hub.reason"))
+		}
+		return
+	default:
+		http.Error(w, "invalid mode", http.StatusBadRequest)
+		return
+	}
+	f.HubMode, f.HubNonce, f.HubExpiry = "", "", nil
+	err := c.Verified(f)
+	if err != nil {
+		log.Printf("websub verify %s: %v", f.Name, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(w, q.Get("hub.challenge"))
+}
+
+// push delivers the content distributed by the hub. Content without a valid
+// signature is acknowledged but ignored.
+func (c *Callback) push(w http.ResponseWriter, r *http.Request, f *Feeder) {
+	if f == nil || !f.Enable || f.Secret == "" {
+		// tell the hub to stop distributing
+		http.Error(w, "gone", http.StatusGone)
+		return
+	}
+	body, err := ioutil.ReadAll(&maxReader{r.Body, MaxFeedSize})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !CheckSignature(f.Secret, body, r.Header.Get("X-Hub-Signature")) {
+		log.Printf("websub %s: ignored content with invalid signature", f.Name)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	feed, err := ReadType(bytes.NewReader(body), r.Header.Get("Content-Type"))
+	if err != nil {
+		log.Printf("websub push %s: %v", f.Name, err)
+		http.Error(w, "invalid feed", http.StatusBadRequest)
+		return
+	}
+	entries := make([]Entry, 0, len(feed.Channel.Item))
+	for _, item := range feed.Channel.Item {
+		entries = append(entries, Entry{Item: item})
+	}
+	err = c.Push(f, entries)
+	if err != nil {
+		log.Printf("websub push %s: %v", f.Name, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+// Copyright 2013 Martin Schnabel. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package feeds
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const hubRss = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+<title>Hub</title>
+<atom:link rel="hub" href="http://hub.example.com/"/>
+<atom:link rel="self" href="http://example.com/feed.xml"/>
+<link>http://example.com/</link>
+<item><title>One</title><link>http://example.com/1</link></item>
+</channel>
+</rss>`
+
+func TestDiscoverHub(t *testing.T) {
+	feed, err := Read(strings.NewReader(hubRss))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Channel.Link != "http://example.com/" {
+		t.Errorf("unexpected channel link %q", feed.Channel.Link)
+	}
+	f := &Feeder{Url: "http://example.com/rss"}
+	f.discoverHub(http.Header{}, feed)
+	if f.Hub != "http://hub.example.com/" || f.Topic != "http://example.com/feed.xml" {
+		t.Errorf("unexpected hub %q topic %q", f.Hub, f.Topic)
+	}
+	h := http.Header{"Link": {`<http://other.example.com/hub>; rel="hub", <http://example.com/self>; rel=self`}}
+	f.discoverHub(h, feed)
+	if f.Hub != "http://other.example.com/hub" || f.Topic != "http://example.com/self" {
+		t.Errorf("unexpected header hub %q topic %q", f.Hub, f.Topic)
+	}
+	feed, err = Read(strings.NewReader(`{"version": "https://jsonfeed.org/version/1.1", "title": "json",
+		"hubs": [{"type": "WebSub", "url": "http://hub.example.com/"}], "items": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.discoverHub(http.Header{}, feed)
+	if f.Hub != "http://hub.example.com/" || f.Topic != f.Url {
+		t.Errorf("unexpected json hub %q topic %q", f.Hub, f.Topic)
+	}
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestCheckSignature(t *testing.T) {
+	body := []byte("content")
+	if !CheckSignature("secret", body, sign("secret", body)) {
+		t.Error("expect valid signature")
+	}
+	for _, sig := range []string{"", "sha256=", "md5=abc", sign("other", body)} {
+		if CheckSignature("secret", body, sig) {
+			t.Errorf("expect invalid signature %q", sig)
+		}
+	}
+}
+
+func TestWebSub(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pushed []Entry
+	cb := httptest.NewServer(&Callback{
+		Feeder: func(id int64) (*Feeder, error) {
+			fs, err := Feeders(db, "where id=?", id)
+			if err != nil || len(fs) == 0 {
+				return nil, err
+			}
+			return &fs[0], nil
+		},
+		Verified: func(f *Feeder) error { return f.SaveHub(db) },
+		Push: func(f *Feeder, entries []Entry) error {
+			pushed = append(pushed, entries...)
+			return nil
+		},
+	})
+	defer cb.Close()
+	// the hub verifies the subscription before accepting it
+	var callbackUrl, verified string
+	verify := func(mode string) {
+		q := url.Values{
+			"hub.mode":          {mode},
+			"hub.topic":         {"http://example.com/feed.xml"},
+			"hub.challenge":     {"challenge"},
+			"hub.lease_seconds": {strconv.Itoa(10 * LeaseSeconds)},
+		}
+		resp, err := http.Get(callbackUrl + "&" + q.Encode())
+		if err != nil {
+			verified = err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		verified = string(body)
+	}
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		callbackUrl = r.Form.Get("hub.callback")
+		verify(r.Form.Get("hub.mode"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+	f, err := NewFeeder(db, "hub", "http://example.com/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Hub, f.Topic = hub.URL, f.Url
+	err = f.Save(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.NeedsLease(time.Now()) {
+		t.Fatal("expect feeder needs lease")
+	}
+	f.Secret, err = NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := CallbackUrl(cb.URL+"/websub/", f.Id)
+	if err = f.HubRequest(callback); err == nil {
+		t.Error("expect error without pending request")
+	}
+	err = f.NewHubRequest("subscribe", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.SaveHub(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.HubRequest(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != "challenge" {
+		t.Errorf("expect challenge got %q", verified)
+	}
+	fs, err := Feeders(db, "where id=?", f.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs[0].Leased(time.Now()) || fs[0].NeedsLease(time.Now()) {
+		t.Errorf("expect leased feeder got %v", fs[0].Lease)
+	}
+	max := time.Now().Add(time.Duration(LeaseSeconds) * time.Second)
+	if fs[0].Lease != nil && fs[0].Lease.After(max) {
+		t.Errorf("expect lease capped at %v got %v", max, fs[0].Lease)
+	}
+	if fs[0].HubMode != "" || fs[0].HubNonce != "" || fs[0].HubExpiry != nil {
+		t.Errorf("expect verified request cleared got %s %s", fs[0].HubMode, fs[0].HubNonce)
+	}
+	// verifications without a pending request are refused
+	for _, mode := range []string{"subscribe", "unsubscribe"} {
+		verify(mode)
+		if verified != "404 page not found\n" {
+			t.Errorf("expect refused %s got %q", mode, verified)
+		}
+	}
+	post := func(url, sig string, body []byte) int {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/rss+xml")
+		req.Header.Set("X-Hub-Signature", sig)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	body := []byte(hubRss)
+	if code := post(callback, sign("wrong", body), body); code != http.StatusAccepted || len(pushed) != 0 {
+		t.Errorf("expect ignored push got %d %d entries", code, len(pushed))
+	}
+	if code := post(callback, sign(f.Secret, body), body); code != http.StatusNoContent || len(pushed) != 1 {
+		t.Errorf("expect pushed entry got %d %d entries", code, len(pushed))
+	}
+	if len(pushed) > 0 && pushed[0].Title != "One" {
+		t.Errorf("unexpected pushed entry %v", pushed[0])
+	}
+	if code := post(CallbackUrl(cb.URL, f.Id+1), sign(f.Secret, body), body); code != http.StatusGone {
+		t.Errorf("expect gone for unknown feeder got %d", code)
+	}
+	// an expired request is refused
+	err = f.NewHubRequest("unsubscribe", time.Now().Add(-2*VerifyTimeout))
+	if err == nil {
+		err = f.SaveHub(db)
+	}
+	if err == nil {
+		err = f.HubRequest(callback)
+	}
+	if err != nil || verified != "404 page not found\n" {
+		t.Errorf("expect refused expired unsubscription got %q %v", verified, err)
+	}
+	err = f.NewHubRequest("unsubscribe", time.Now())
+	if err == nil {
+		err = f.SaveHub(db)
+	}
+	if err == nil {
+		err = f.HubRequest(callback)
+	}
+	if err != nil || verified != "challenge" {
+		t.Errorf("expect unsubscription got %q %v", verified, err)
+	}
+	fs, err = Feeders(db, "where id=?", f.Id)
+	if err != nil || fs[0].Lease != nil {
+		t.Errorf("expect unsubscribed feeder got %v %v", fs[0].Lease, err)
+	}
+}
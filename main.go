@@ -10,9 +10,16 @@ import (
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"os"
+	"time"
 )
 
 var username = flag.String("user", "vmail", "vmail username")
+var workers = flag.Int("workers", 4, "number of concurrent feed checks")
+var perhost = flag.Int("perhost", 2, "number of concurrent feed checks per host")
+var interval = flag.Duration("interval", 30*time.Minute, "default feed check interval in feedd")
+var listen = flag.String("listen", "", "address of the websub callback listener in feedd, e.g. :8025")
+var callback = flag.String("callback", "", "public base url of the websub callback listener, enables websub in feedd")
+var maxfail = flag.Int("maxfail", 10, "disable feeds after this many consecutive failures, 0 never")
 
 func main() {
 	flag.Usage = usage
@@ -21,7 +28,7 @@ func main() {
 	if err != nil {
 		fail(err)
 	}
-	p := &prog{conf}
+	p := &prog{conf: conf, maxfail: *maxfail, workers: *workers, perhost: *perhost}
 	switch flag.Arg(0) {
 	case "setup":
 		p.setup()
@@ -48,11 +55,39 @@ func main() {
 		email := flag.Arg(1)
 		err = p.remove(email)
 	case "feed":
-		name, url := flag.Arg(1), flag.Arg(2)
-		err = p.feed(name, url)
+		switch name := flag.Arg(1); name {
+		case "import":
+			err = p.feedImport(flag.Arg(2))
+		case "export":
+			err = p.feedExport()
+		case "show":
+			err = p.feedShow(flag.Arg(2))
+		case "remove":
+			err = p.feedRemove(flag.Arg(2), flag.Arg(3))
+		case "rename":
+			err = p.feedRename(flag.Arg(2), flag.Arg(3))
+		case "enable", "disable":
+			err = p.feedEnable(flag.Arg(2), name == "enable")
+		default:
+			url, interval := flag.Arg(2), flag.Arg(3)
+			err = p.feed(name, url, interval)
+		}
 	case "checkfeed":
 		name := flag.Arg(1)
 		err = p.checkFeed(name)
+	case "feedset":
+		name, option, value := flag.Arg(1), flag.Arg(2), flag.Arg(3)
+		err = p.feedSet(name, option, value)
+	case "feedfilter":
+		err = p.feedFilter(flag.Arg(1), flag.Args()[1:])
+	case "feedd":
+		err = p.feedd(*workers, *interval, *listen, *callback)
+	case "subscribe":
+		addr, name, folder := flag.Arg(1), flag.Arg(2), flag.Arg(3)
+		err = p.subscribe(addr, name, folder)
+	case "unsubscribe":
+		addr, name := flag.Arg(1), flag.Arg(2)
+		err = p.unsubscribe(addr, name)
 	default:
 		failUsage("unknown command: ", flag.Arg(0))
 	}
@@ -86,6 +121,43 @@ command:
   create: creates a mailbox
   alias:  creates an alias
   remove: removes an alias or mailbox
+  feed:   lists feeds or creates and updates a feed
+      name url [interval]
+      import file.opml
+      export
+      show name
+      remove name [delete|archive]
+      rename name newname
+      enable|disable name
+  checkfeed: checks a feed or all feeds with '*'
+  feedd:  runs the feed daemon
+  subscribe: delivers a feed into the maildir of a mailbox
+      user@host feed [path/of/folders]
+  unsubscribe: stops delivering a feed to a mailbox
+      user@host feed
+  feedset: sets a feed option
+      name updates ignore|notify|replace
+      name folder path/of/folders
+      name enable true|false
+      name images true|false
+      name fulltext true|false
+      name enclosures link|attach|store
+      name maxenclosure bytes
+      name digest off|daily|weekly
+      name categories off|keywords|folders|both
+      name keep count
+      name maxage 30d
+      name keepflagged|keepunread true|false
+      name useragent|proxy string
+      name auth user:password|'Bearer token'
+      name headers 'Key: value' (an empty value removes the header)
+      name maxbody bytes
+      name redirects n (-1 disables redirects)
+  feedfilter: manages entry filter rules of feeds
+      add feed include|exclude title|link|category|author|content regexp
+      list [feed]
+      remove id
+      dryrun feed
   config: prints configuration to stdout
       sql
       postfix_domain
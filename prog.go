@@ -5,14 +5,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"database/sql"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/mb0/vmail/email"
@@ -25,6 +34,35 @@ import (
 
 type prog struct {
 	conf *Config
+	// daemon is set when running as feed daemon
+	daemon bool
+	// maxfail is the number of consecutive failures after which feeds are disabled
+	maxfail int
+	// workers is the number of concurrent feed checks
+	workers int
+	// perhost is the number of concurrent checks of feeds on the same host
+	perhost int
+	// wr serializes the database writes of concurrent feed checks
+	wr *feeds.Writer
+}
+
+// write calls fn with db or with the database of the writer if checks run
+// concurrently.
+func (p *prog) write(db *sql.DB, fn func(*sql.DB) error) error {
+	if p.wr == nil {
+		return fn(db)
+	}
+	return p.wr.Do(fn)
+}
+
+// report prints a message about feeder f.
+func (p *prog) report(f feeds.Feeder, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if p.daemon {
+		log.Printf("%s: %s", f.Name, msg)
+		return
+	}
+	fmt.Printf("\t%s: %s\n", f.Name, msg)
 }
 
 func ensureFile(name string, mode os.FileMode, content io.Reader) (created bool, err error) {
@@ -57,21 +95,20 @@ func (p *prog) setup() {
 	}
 	fmt.Println("init vmail env in", p.conf.HomeDir)
 	dbpath := p.conf.DbFile()
-	createdb, err := ensureFile(dbpath, 0644, nil)
+	_, err = ensureFile(dbpath, 0644, nil)
 	if err != nil {
 		fail("could not create", dbpath, err)
 	}
-	if createdb {
-		db := open(p.conf)
-		defer db.Close()
-		err = store.Create(db)
-		if err != nil {
-			fail("could not create vmail tables", err)
-		}
-		err = feeds.Create(db)
-		if err != nil {
-			fail("could not create feeder tables", err)
-		}
+	// creates missing tables and migrates existing ones
+	db := open(p.conf)
+	defer db.Close()
+	err = store.Create(db)
+	if err != nil {
+		fail("could not create vmail tables", err)
+	}
+	err = feeds.Create(db)
+	if err != nil {
+		fail("could not create feeder tables", err)
 	}
 	feedsdir := p.conf.FeedsDir()
 	_, err = os.Stat(feedsdir)
@@ -180,7 +217,7 @@ func (p *prog) remove(addr string) error {
 	return store.Delete(db, "where name=? and domain=?", e.User(), e.Domain())
 }
 
-func (p *prog) feed(name, url string) error {
+func (p *prog) feed(name, url, interval string) error {
 	db := open(p.conf)
 	defer db.Close()
 	if name == "" {
@@ -190,134 +227,1635 @@ func (p *prog) feed(name, url string) error {
 			return err
 		}
 		for _, f := range feeders {
-			fmt.Printf("%s\t[%s]\n", f.Name, f.Url)
+			var info string
+			if f.Interval > 0 {
+				info += " every " + f.Interval.String()
+			}
+			if !f.Enable {
+				info += " disabled"
+			}
+			fmt.Printf("%s\t[%s]%s\n", f.Name, f.Url, info)
 		}
 		return nil
 	}
+	var d time.Duration
+	if interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil {
+			return err
+		}
+		if parsed < time.Minute {
+			return fmt.Errorf("feed interval must be at least one minute")
+		}
+		d = parsed
+	}
 	feeders, err := feeds.Feeders(db, "where name=?", name)
 	if err != nil {
 		return err
 	}
+	if len(feeders) == 0 || feeders[0].Url != url {
+		url, err = discover(url)
+		if err != nil {
+			return err
+		}
+	}
 	if len(feeders) > 0 {
 		// update feed
 		f := feeders[0]
-		if f.Url == url {
-			fmt.Printf("feed %s has already url %s", name, url)
+		if f.Url == url && (d == 0 || f.Interval == d) {
+			fmt.Printf("feed %s has already url %s\n", name, url)
 			return nil
 		}
-		return feeds.UpdateFeeder(db, name, url)
+		if f.Url != url {
+			err = feeds.UpdateFeeder(db, name, url)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		// create new feed
+		if isFeedCommand(name) {
+			return fmt.Errorf("feed name %q is reserved", name)
+		}
+		if feeds.CleanName(name) != name {
+			return fmt.Errorf("feed name must only contain lower case letters, digits, '-' and '_'")
+		}
+		_, err = feeds.NewFeeder(db, name, url)
+		if err != nil {
+			return err
+		}
 	}
-	// create new feed
-	_, err = feeds.NewFeeder(db, name, url)
-	return err
+	if d == 0 {
+		return nil
+	}
+	return feeds.UpdateInterval(db, name, d)
 }
 
-func (p *prog) checkFeed(name string) error {
-	feeders, err := p.getFeeders(name)
+// discover returns the feed url for the page at url. If the page links to
+// multiple valid feeds the user is asked to pick one, the first is used if
+// stdin is no terminal.
+func discover(url string) (string, error) {
+	var ft feeds.Fetcher
+	cands, err := feeds.Discover(&ft, url)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if len(cands) == 1 && cands[0].Url == url {
+		return url, nil
+	}
+	valid := make([]feeds.Candidate, 0, len(cands))
+	for _, c := range cands {
+		if err := c.Validate(); err == nil {
+			valid = append(valid, c)
+		}
+	}
+	if len(valid) == 0 {
+		return "", fmt.Errorf("no feed found for %s", url)
+	}
+	pick := 0
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 && len(valid) > 1 {
+		for i, c := range valid {
+			fmt.Printf("%d\t%s\n", i+1, &c)
+		}
+		for {
+			fmt.Print("Pick a feed [1]: ")
+			var line string
+			fmt.Scanln(&line)
+			if line == "" {
+				break
+			}
+			n, err := strconv.Atoi(line)
+			if err == nil && n > 0 && n <= len(valid) {
+				pick = n - 1
+				break
+			}
+		}
+	}
+	fmt.Println("found feed", &valid[pick])
+	return valid[pick].Url, nil
+}
+
+// isFeedCommand returns whether name is a feed sub command.
+func isFeedCommand(name string) bool {
+	switch name {
+	case "import", "export", "show", "remove", "rename", "enable", "disable":
+		return true
+	}
+	return false
+}
+
+func findFeeder(db *sql.DB, name string) (*feeds.Feeder, error) {
+	feeders, err := feeds.Feeders(db, "where name=?", name)
+	if err != nil {
+		return nil, err
 	}
 	if len(feeders) < 1 {
-		return fmt.Errorf("no feeder named '%s'", name)
+		return nil, fmt.Errorf("no feeder named '%s'", name)
 	}
-	for _, f := range feeders {
-		fmt.Printf("check feeder %s\n", f.Name)
-		err = p.checkEntries(f)
-		if err != nil && err != feeds.ErrGetHTTP {
+	return &feeders[0], nil
+}
+
+func (p *prog) feedSet(name, option, value string) error {
+	db := open(p.conf)
+	defer db.Close()
+	f, err := findFeeder(db, name)
+	if err != nil {
+		return err
+	}
+	var val interface{}
+	switch option {
+	case "updates":
+		val, err = feeds.ParseUpdates(value)
+	case "folder":
+		val, err = cleanFolder(value)
+		if err == nil {
+			to := feeds.Feeder{Name: f.Name, Folder: val.(string)}
+			err = moveMaildir(p.conf, *f, to.Mailbox())
+		}
+	case "enclosures":
+		val, err = feeds.ParseEnclosures(value)
+	case "categories":
+		val, err = feeds.ParseCategories(value)
+	case "enable":
+		// kept as alias of feed enable and disable
+		var enable bool
+		enable, err = strconv.ParseBool(value)
+		if err != nil {
 			return err
 		}
+		return feeds.EnableFeeder(db, f.Id, enable)
+	case "digest":
+		val, err = feeds.ParseDigest(value)
+	case "images", "fulltext", "keepflagged", "keepunread":
+		val, err = strconv.ParseBool(value)
+	case "keep":
+		var n int
+		n, err = strconv.Atoi(value)
+		if err == nil && n < 0 {
+			err = fmt.Errorf("keep must not be negative")
+		}
+		val = n
+	case "maxage":
+		var d time.Duration
+		d, err = parseAge(value)
+		val = int64(d / time.Second)
+	case "useragent":
+		val = value
+	case "auth":
+		val = value
+		ft := feeds.Fetcher{Auth: value}
+		_, err = ft.Request("GET", f.Url)
+	case "proxy":
+		val = value
+		if u, perr := url.Parse(value); value != "" && (perr != nil || u.Host == "") {
+			err = fmt.Errorf("invalid proxy %q", value)
+		}
+	case "headers":
+		val, err = mergeHeader(f.Fetcher.Header, value)
+	case "maxbody", "redirects", "maxenclosure":
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 64)
+		if err == nil && option != "redirects" && n < 0 {
+			err = fmt.Errorf("%s must not be negative", option)
+		}
+		val = n
+	default:
+		err = fmt.Errorf("feedset requires option %s or enable", strings.Join(feeds.Options, ", "))
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+	return feeds.SetOption(db, f.Id, option, val)
 }
-func (p *prog) getFeeders(name string) (fs []feeds.Feeder, err error) {
+
+// mergeHeader adds or replaces the headers in lines to h and returns the
+// formatted result. Headers with empty values are removed.
+func mergeHeader(h http.Header, lines string) (string, error) {
+	add, err := feeds.ParseHeader(lines)
+	if err != nil {
+		return "", err
+	}
+	res := make(http.Header, len(h)+len(add))
+	for k, v := range h {
+		res[k] = v
+	}
+	for k, v := range add {
+		if len(v) == 0 || len(v) == 1 && v[0] == "" {
+			delete(res, k)
+			continue
+		}
+		res[k] = v
+	}
+	return feeds.FormatHeader(res), nil
+}
+
+// parseAge parses a duration that may use the unit d for days.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("age must not be negative")
+	}
+	return d, err
+}
+
+// cleanFolder returns the folder path with clean names or an error.
+func cleanFolder(folder string) (string, error) {
+	if folder == "" {
+		return "", nil
+	}
+	names := strings.Split(strings.Trim(folder, "/"), "/")
+	for i, name := range names {
+		names[i] = feeds.CleanName(name)
+		if names[i] == "" {
+			return "", fmt.Errorf("invalid folder %q", folder)
+		}
+	}
+	return strings.Join(names, "/"), nil
+}
+
+// feedShow prints the settings and state of the feed.
+func (p *prog) feedShow(name string) error {
 	db := open(p.conf)
 	defer db.Close()
-	if name == "*" {
-		return feeds.Feeders(db, "")
+	f, err := findFeeder(db, name)
+	if err != nil {
+		return err
 	}
-	return feeds.Feeders(db, "where name=?", name)
+	count, err := f.Count(db)
+	if err != nil {
+		return err
+	}
+	rules, err := f.Rules(db)
+	if err != nil {
+		return err
+	}
+	subs, err := subscriberBoxes(db, f.Id)
+	if err != nil {
+		return err
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return t.Format(time.RFC1123Z)
+	}
+	interval := "default"
+	if f.Interval > 0 {
+		interval = f.Interval.String()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", f.Name)
+	fmt.Fprintf(w, "url:\t%s\n", f.Url)
+	fmt.Fprintf(w, "enabled:\t%v\n", f.Enable)
+	if len(subs) == 0 {
+		fmt.Fprintf(w, "mailbox:\t%s\n", mailboxPath(p.conf, f.Mailbox()))
+	}
+	for _, s := range subs {
+		fmt.Fprintf(w, "subscriber:\t%s\n", userMaildirPath(p.conf, s.user, s.Mailbox(f.Name)))
+	}
+	fmt.Fprintf(w, "interval:\t%s\n", interval)
+	fmt.Fprintf(w, "updates:\t%s\n", feeds.FormatUpdates(f.Updates))
+	fmt.Fprintf(w, "images:\t%v\n", f.Images)
+	fmt.Fprintf(w, "fulltext:\t%v\n", f.Fulltext)
+	fmt.Fprintf(w, "enclosures:\t%s\n", feeds.FormatEnclosures(f.Enclosures))
+	if f.MaxEnclosure > 0 {
+		fmt.Fprintf(w, "max enclosure:\t%d bytes\n", f.MaxEnclosure)
+	}
+	fmt.Fprintf(w, "categories:\t%s\n", feeds.FormatCategories(f.Categories))
+	if len(f.Subfolders) > 0 {
+		fmt.Fprintf(w, "category folders:\t%s\n", strings.Join(f.Subfolders, " "))
+	}
+	fmt.Fprintf(w, "digest:\t%s\n", feeds.FormatDigest(f.Digest))
+	if f.Digest != feeds.DigestOff {
+		fmt.Fprintf(w, "last digest:\t%s\n", formatTime(f.Digested))
+	}
+	if f.Retains() {
+		retain := "all"
+		if f.Keep > 0 {
+			retain = fmt.Sprintf("%d messages", f.Keep)
+		}
+		if f.MaxAge > 0 {
+			retain += " for " + f.MaxAge.String()
+		}
+		if f.KeepFlagged {
+			retain += ", flagged"
+		}
+		if f.KeepUnread {
+			retain += ", unread"
+		}
+		fmt.Fprintf(w, "retain:\t%s\n", retain)
+	}
+	ft := f.Fetcher
+	if ft.UserAgent != "" {
+		fmt.Fprintf(w, "user agent:\t%s\n", ft.UserAgent)
+	}
+	if ft.Proxy != "" {
+		fmt.Fprintf(w, "proxy:\t%s\n", ft.Proxy)
+	}
+	if ft.Auth != "" {
+		// do not print the credentials
+		fmt.Fprintf(w, "auth:\tset\n")
+	}
+	if len(ft.Header) > 0 {
+		// headers like cookies hold credentials, only print the names
+		names := make([]string, 0, len(ft.Header))
+		for k := range ft.Header {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Fprintf(w, "header:\t%s: set\n", k)
+		}
+	}
+	if ft.MaxBody > 0 {
+		fmt.Fprintf(w, "max body:\t%d bytes\n", ft.MaxBody)
+	}
+	if ft.Redirects != 0 {
+		fmt.Fprintf(w, "redirects:\t%d\n", ft.Redirects)
+	}
+	if f.Hub != "" {
+		fmt.Fprintf(w, "websub hub:\t%s\n", f.Hub)
+		fmt.Fprintf(w, "websub topic:\t%s\n", f.Topic)
+		fmt.Fprintf(w, "websub lease:\t%s\n", formatTime(f.Lease))
+	}
+	fmt.Fprintf(w, "filter rules:\t%d\n", len(rules))
+	fmt.Fprintf(w, "last fetch:\t%s\n", formatTime(f.Checked))
+	fmt.Fprintf(w, "last status:\t%d\n", f.Status)
+	fmt.Fprintf(w, "last fed:\t%s\n", formatTime(f.Time))
+	fmt.Fprintf(w, "entries:\t%d\n", count)
+	fmt.Fprintf(w, "last error:\t%s\n", f.Error)
+	if f.Failures > 0 {
+		fmt.Fprintf(w, "failures:\t%d\n", f.Failures)
+		fmt.Fprintf(w, "retry:\t%s\n", formatTime(f.Retry))
+	}
+	return w.Flush()
 }
 
-func ensureMaildir(conf *Config, name string) (*maildir.Maildir, error) {
-	uid, _ := strconv.Atoi(conf.Uid)
-	gid, _ := strconv.Atoi(conf.Gid)
-	root, err := maildir.NewWithPerm(conf.FeedsDir(), true, maildir.DefaultFilePerm, uid, gid)
+// feedRemove removes the feed, its subscriptions and its history. The feed
+// mailboxes are kept, unless mode is delete or archive, which moves them into the
+// archive folder. This applies to the public feed mailbox and the mailboxes of
+// the subscribers, each with its category folders.
+func (p *prog) feedRemove(name, mode string) error {
+	if mode != "" && mode != "delete" && mode != "archive" {
+		return fmt.Errorf("feed remove mode must be delete or archive")
+	}
+	db := open(p.conf)
+	defer db.Close()
+	f, err := findFeeder(db, name)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	child, err := root.Child(name, true)
+	subs, err := subscriberBoxes(db, f.Id)
+	if err != nil {
+		return err
+	}
+	paths := []string{mailboxPath(p.conf, f.Mailbox())}
+	archives := []string{mailboxPath(p.conf, "archive."+f.Mailbox())}
+	for _, s := range subs {
+		box := s.Mailbox(f.Name)
+		paths = append(paths, userMaildirPath(p.conf, s.user, box))
+		archives = append(archives, userMaildirPath(p.conf, s.user, "archive."+box))
+	}
+	for i, path := range paths {
+		switch mode {
+		case "delete":
+			for _, path := range categoryPaths(*f, path) {
+				if _, err := os.Stat(path); err != nil {
+					continue
+				}
+				fmt.Println("deleting", path)
+				err = os.RemoveAll(path)
+				if err != nil {
+					return err
+				}
+			}
+		case "archive":
+			err = moveFolders(*f, path, archives[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// deleting the feeder also deletes its subscriptions
+	err = feeds.DeleteFeeder(db, f.Id)
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		fmt.Println("unsubscribed", s.user, "from", f.Name)
+	}
+	return nil
+}
+
+// findBox returns the enabled mailbox dest for addr.
+func findBox(db *sql.DB, addr string) (*store.Dest, error) {
+	e, err := email.ParseAddr(addr)
 	if err != nil {
 		return nil, err
 	}
-	_, err = ensureFile(filepath.Join(child.Path, "dovecot-acl"), 0664, strings.NewReader("authenticated lrst\n"))
+	dests, err := store.Dests(db, "where name=? and domain=? and type=?", e.User(), e.Domain(), store.TypeBox)
 	if err != nil {
 		return nil, err
 	}
-	return child, nil
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("no mailbox %s", addr)
+	}
+	return &dests[0], nil
 }
 
-func (p *prog) checkEntries(f feeds.Feeder) error {
-	addr, err := email.ParseAddr(fmt.Sprintf(`"%s" <%s@feeds>`, f.Name, f.Name))
+// subscribe subscribes the mailbox addr to the feed. The feed is delivered to
+// the folder in the user's maildir, Feeds and the feed's folder by default.
+func (p *prog) subscribe(addr, name, folder string) error {
+	db := open(p.conf)
+	defer db.Close()
+	d, err := findBox(db, addr)
 	if err != nil {
 		return err
 	}
-	maildir, err := ensureMaildir(p.conf, f.Name)
+	f, err := findFeeder(db, name)
 	if err != nil {
 		return err
 	}
-	entries, err := f.Entries()
+	if folder == "" {
+		folder = path.Join("Feeds", f.Folder)
+	}
+	folder, err = cleanFolder(folder)
+	if err != nil {
+		return err
+	}
+	err = feeds.Subscribe(db, f.Id, d.Id, folder)
 	if err != nil {
 		return err
 	}
+	s := feeds.Subscriber{Folder: folder}
+	fmt.Println("subscribed", addr, "to", f.Name, "in", userMaildirPath(p.conf, addr, s.Mailbox(f.Name)))
+	return nil
+}
+
+func (p *prog) unsubscribe(addr, name string) error {
 	db := open(p.conf)
 	defer db.Close()
-	entries, err = f.Filter(db, entries)
+	d, err := findBox(db, addr)
 	if err != nil {
 		return err
 	}
-	filtered := make([]feeds.Entry, 0, len(entries))
-	for _, e := range entries {
-		if strings.Contains(e.Link, "sportschau.de") {
-			continue
-		}
-		filtered = append(filtered, e)
+	f, err := findFeeder(db, name)
+	if err != nil {
+		return err
 	}
-	entries = filtered
-	if len(entries) == 0 {
-		fmt.Println("\tno new entries")
-		return nil
+	return feeds.Unsubscribe(db, f.Id, d.Id)
+}
+
+// feedRename renames the feed and moves its mailbox. The fed entries are kept.
+func (p *prog) feedRename(name, newname string) error {
+	if feeds.CleanName(newname) != newname || isFeedCommand(newname) {
+		return fmt.Errorf("invalid feed name %q", newname)
 	}
-	var written []feeds.Entry
-	for _, e := range entries {
-		r, err := e.Html()
+	db := open(p.conf)
+	defer db.Close()
+	f, err := findFeeder(db, name)
+	if err != nil {
+		return err
+	}
+	err = feeds.RenameFeeder(db, f.Id, newname)
+	if err != nil {
+		return err
+	}
+	to := *f
+	to.Name = newname
+	err = moveMaildir(p.conf, *f, to.Mailbox())
+	if err != nil {
+		// keep the name matching the mailbox
+		feeds.RenameFeeder(db, f.Id, f.Name)
+		return err
+	}
+	subs, err := subscriberBoxes(db, f.Id)
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		src := userMaildirPath(p.conf, s.user, s.Mailbox(f.Name))
+		dst := userMaildirPath(p.conf, s.user, s.Mailbox(newname))
+		err = moveFolders(*f, src, dst)
 		if err != nil {
 			log.Println(err)
-			continue
 		}
-		m := email.NewMsg(addr, e.Title, addr)
-		dtime, err := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", e.PubDate)
+	}
+	return nil
+}
+
+// subscriberBox is a subscriber with the address of its mailbox.
+type subscriberBox struct {
+	feeds.Subscriber
+	user string
+}
+
+// subscriberBoxes returns the subscribers of the feeder with enabled mailboxes.
+func subscriberBoxes(db *sql.DB, feeder int64) ([]subscriberBox, error) {
+	subs, err := feeds.Subscribers(db, "where feeder=?", feeder)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]subscriberBox, 0, len(subs))
+	for _, s := range subs {
+		dests, err := store.Dests(db, "where id=? and type=? and enable=1", s.Dest, store.TypeBox)
 		if err != nil {
-			dtime = time.Now()
+			return nil, err
 		}
-		m.Header.Set("Date", dtime.Format(time.RFC822))
-		err = m.AddHtml(r)
-		if err != nil {
-			log.Println(err)
+		if len(dests) > 0 {
+			res = append(res, subscriberBox{s, dests[0].Name + "@" + dests[0].Domain})
+		}
+	}
+	return res, nil
+}
+
+func (p *prog) feedEnable(name string, enable bool) error {
+	db := open(p.conf)
+	defer db.Close()
+	f, err := findFeeder(db, name)
+	if err != nil {
+		return err
+	}
+	return feeds.EnableFeeder(db, f.Id, enable)
+}
+
+// feedImport creates feeds for all subscriptions in the opml file. Subscriptions
+// conflicting with existing feed names or urls are reported and skipped.
+func (p *prog) feedImport(file string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	subs, err := feeds.ReadOpml(r)
+	if err != nil {
+		return err
+	}
+	db := open(p.conf)
+	defer db.Close()
+	feeders, err := feeds.Feeders(db, "")
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(feeders))
+	urls := make(map[string]string, len(feeders))
+	for _, f := range feeders {
+		names[f.Name] = f.Url
+		urls[f.Url] = f.Name
+	}
+	var created, skipped int
+	for _, s := range subs {
+		if s.Name == "" {
+			fmt.Printf("skip %s: no valid name\n", s.Url)
+			skipped++
 			continue
 		}
-		var buf bytes.Buffer
-		m.WriteTo(&buf)
-		_, err = maildir.CreateMail(&buf)
+		if url, ok := names[s.Name]; ok {
+			if url != s.Url {
+				fmt.Printf("skip %s: name used by %s\n", s.Url, url)
+			}
+			skipped++
+			continue
+		}
+		if name, ok := urls[s.Url]; ok {
+			fmt.Printf("skip %s: url used by feed %s\n", s.Name, name)
+			skipped++
+			continue
+		}
+		f, err := feeds.NewFeeder(db, s.Name, s.Url)
 		if err != nil {
-			log.Println(err)
+			fmt.Printf("skip %s: %v\n", s.Name, err)
+			skipped++
 			continue
 		}
-		written = append(written, e)
+		if s.Folder != "" {
+			err = feeds.SetOption(db, f.Id, "folder", s.Folder)
+			if err != nil {
+				return err
+			}
+		}
+		names[s.Name], urls[s.Url] = s.Url, s.Name
+		fmt.Printf("created %s\t[%s] in %q\n", s.Name, s.Url, s.Folder)
+		created++
 	}
-	err = f.Fed(db, written, time.Now())
+	fmt.Printf("imported %d feeds, skipped %d\n", created, skipped)
+	return nil
+}
+
+func (p *prog) feedExport() error {
+	db := open(p.conf)
+	defer db.Close()
+	feeders, err := feeds.Feeders(db, "order by folder, name")
 	if err != nil {
 		return err
 	}
-	fmt.Printf("\tgot %d entries %d of them are new\n", len(entries), len(written))
-	return f.Prune(db, 256)
+	return feeds.WriteOpml(os.Stdout, "vmail feeds", feeders)
+}
+
+func (p *prog) feedFilter(cmd string, args []string) error {
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	db := open(p.conf)
+	defer db.Close()
+	switch cmd {
+	case "add":
+		if len(args) != 4 {
+			return fmt.Errorf("feedfilter add requires feed, action, field and pattern")
+		}
+		f, err := findFeeder(db, args[0])
+		if err != nil {
+			return err
+		}
+		action, err := feeds.ParseAction(args[1])
+		if err != nil {
+			return err
+		}
+		r, err := feeds.NewRule(db, f.Id, action, args[2], args[3])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", f.Name, r)
+		return nil
+	case "list":
+		var rules []feeds.Rule
+		feeders, err := feeds.Feeders(db, "")
+		if err != nil {
+			return err
+		}
+		names := make(map[int64]string, len(feeders))
+		for _, f := range feeders {
+			names[f.Id] = f.Name
+		}
+		if name := arg(0); name != "" {
+			f, err := findFeeder(db, name)
+			if err != nil {
+				return err
+			}
+			rules, err = f.Rules(db)
+		} else {
+			rules, err = feeds.Rules(db, "")
+		}
+		if err != nil {
+			return err
+		}
+		if len(rules) == 0 {
+			fmt.Fprintln(os.Stderr, "no results")
+		}
+		for _, r := range rules {
+			fmt.Printf("%s\t%s\n", names[r.Feeder], &r)
+		}
+		return nil
+	case "remove":
+		id, err := strconv.ParseInt(arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("feedfilter remove requires a rule id")
+		}
+		return feeds.DeleteRule(db, id)
+	case "dryrun":
+		f, err := findFeeder(db, arg(0))
+		if err != nil {
+			return err
+		}
+		rules, err := f.Rules(db)
+		if err != nil {
+			return err
+		}
+		// request the whole feed
+		f.ETag, f.Modified = "", ""
+		entries, err := f.Entries()
+		if err != nil {
+			return err
+		}
+		entries, err = f.Filter(db, entries)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("no new entries")
+		}
+		for _, e := range entries {
+			if ok, reason := feeds.Check(rules, &e); ok {
+				fmt.Printf("keep\t%s\n", e.Title)
+			} else {
+				fmt.Printf("drop\t%s\t%s\n", e.Title, reason)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("feedfilter requires add, list, remove or dryrun")
+}
+
+func (p *prog) checkFeed(name string) error {
+	feeders, err := p.getFeeders(name)
+	if err != nil {
+		return err
+	}
+	if len(feeders) < 1 {
+		return fmt.Errorf("no feeder named '%s'", name)
+	}
+	now := time.Now()
+	due := feeders[:0]
+	for _, f := range feeders {
+		if name == "*" && !f.Due(now) {
+			fmt.Printf("skip feeder %s until %s\n", f.Name, f.Retry.Format(time.Stamp))
+			continue
+		}
+		due = append(due, f)
+	}
+	p.wr = feeds.NewWriter(open(p.conf))
+	defer func() {
+		p.wr.Close()
+		p.wr.DB().Close()
+		p.wr = nil
+	}()
+	pool := feeds.NewPool(p.workers, p.perhost)
+	errs := pool.Run(due, func(f feeds.Feeder) error {
+		fmt.Printf("check feeder %s\n", f.Name)
+		return p.check(f)
+	})
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			p.report(due[i], "error: %v", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds failed", failed, len(due))
+	}
+	return nil
+}
+
+// check checks the entries of feeder f and records the error if it fails.
+func (p *prog) check(f feeds.Feeder) error {
+	err := p.checkEntries(f)
+	if err == nil {
+		err = p.expire(f)
+	}
+	db := open(p.conf)
+	defer db.Close()
+	if err == nil {
+		if f.Failures == 0 && f.Error == "" && f.Retry == nil {
+			return nil
+		}
+		return p.write(db, f.Recovered)
+	}
+	ferr := p.write(db, func(db *sql.DB) error {
+		return f.Failed(db, err, p.maxfail)
+	})
+	if ferr != nil {
+		log.Println(ferr)
+	} else if !f.Enable && feeds.IsGone(err) {
+		p.report(f, "disabled because the feed is gone")
+	} else if !f.Enable {
+		p.report(f, "disabled after %d consecutive failures", f.Failures)
+	}
+	return err
+}
+
+func (p *prog) getFeeders(name string) (fs []feeds.Feeder, err error) {
+	db := open(p.conf)
+	defer db.Close()
+	if name == "*" {
+		return feeds.Feeders(db, "where enable=1")
+	}
+	return feeds.Feeders(db, "where name=?", name)
+}
+
+// expire removes the messages expired by the retention policy of feeder f from
+// all its mailboxes and the stored enclosures no kept message refers to.
+func (p *prog) expire(f feeds.Feeder) error {
+	if !f.Retains() {
+		return nil
+	}
+	db := open(p.conf)
+	defer db.Close()
+	boxes, err := feedMaildirs(p.conf, db, f, "")
+	if err != nil {
+		return err
+	}
+	// messages are only read for enclosures if some were stored
+	dir := enclosureDir(p.conf, f)
+	_, err = os.Stat(dir)
+	stored := err == nil
+	expired, kept := make(map[string]bool), make(map[string]bool)
+	now := time.Now()
+	var n int
+	for _, md := range boxes {
+		for _, path := range categoryPaths(f, md.Path) {
+			msgs, err := listMail(path)
+			if err != nil {
+				return err
+			}
+			gone := make(map[string]bool)
+			for _, m := range f.Expire(msgs, now) {
+				gone[m.Path] = true
+			}
+			for _, m := range msgs {
+				if stored {
+					refs, err := mailEnclosures(m.Path)
+					if err != nil && !os.IsNotExist(err) {
+						return err
+					}
+					for _, ref := range refs {
+						if gone[m.Path] {
+							expired[ref] = true
+						} else {
+							kept[ref] = true
+						}
+					}
+				}
+				if !gone[m.Path] {
+					continue
+				}
+				err = os.Remove(m.Path)
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				n++
+			}
+		}
+	}
+	for ref := range expired {
+		if kept[ref] {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir, ref+"-*"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			err = os.Remove(file)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	if n > 0 {
+		p.report(f, "expired %d messages", n)
+	}
+	return nil
+}
+
+// listMail returns the messages in the maildir at path. Flags are read from the
+// file names, messages in new are unread.
+func listMail(path string) ([]feeds.Message, error) {
+	var res []feeds.Message
+	for _, sub := range []string{"new", "cur"} {
+		infos, err := ioutil.ReadDir(filepath.Join(path, sub))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			if fi.IsDir() {
+				continue
+			}
+			m := feeds.Message{Path: filepath.Join(path, sub, fi.Name()), Time: fi.ModTime()}
+			if i := strings.Index(fi.Name(), ":2,"); i >= 0 && sub == "cur" {
+				flags := fi.Name()[i+3:]
+				m.Seen = strings.ContainsRune(flags, 'S')
+				m.Flagged = strings.ContainsRune(flags, 'F')
+			}
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// mailboxPath returns the maildir++ path of the feed mailbox.
+func mailboxPath(conf *Config, mailbox string) string {
+	return filepath.Join(conf.FeedsDir(), "."+mailbox)
+}
+
+// moveMaildir moves the mailbox of feeder f with its category folders to the
+// mailbox named to if it exists.
+func moveMaildir(conf *Config, f feeds.Feeder, to string) error {
+	if f.Mailbox() == to {
+		return nil
+	}
+	return moveFolders(f, mailboxPath(conf, f.Mailbox()), mailboxPath(conf, to))
+}
+
+// moveFolders moves the maildir at src and the category folders of feeder f
+// below it to dst.
+func moveFolders(f feeds.Feeder, src, dst string) error {
+	err := movePath(src, dst)
+	if err != nil {
+		return err
+	}
+	for _, name := range f.Subfolders {
+		err = movePath(src+"."+name, dst+"."+name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movePath moves the maildir at src to dst if it exists.
+func movePath(src, dst string) error {
+	_, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(dst)
+	if err == nil {
+		return fmt.Errorf("mailbox %s already exists", dst)
+	}
+	fmt.Println("moving", src, "to", dst)
+	return os.Rename(src, dst)
+}
+
+// feedMaildirs returns the maildirs the entries of feeder f are delivered to.
+// These are the mailboxes of all enabled subscribers or the public feed mailbox
+// if the feeder has no subscribers. If folder is not empty the child folders
+// with that name are returned instead.
+func feedMaildirs(conf *Config, db *sql.DB, f feeds.Feeder, folder string) ([]*maildir.Maildir, error) {
+	all, err := feeds.Subscribers(db, "where feeder=?", f.Id)
+	if err != nil {
+		return nil, err
+	}
+	child := func(mailbox string) string {
+		if folder == "" {
+			return mailbox
+		}
+		return mailbox + "." + folder
+	}
+	if len(all) == 0 {
+		md, err := ensureMaildir(conf, child(f.Mailbox()))
+		if err != nil {
+			return nil, err
+		}
+		return []*maildir.Maildir{md}, nil
+	}
+	subs, err := subscriberBoxes(db, f.Id)
+	if err != nil {
+		return nil, err
+	}
+	var res []*maildir.Maildir
+	for _, s := range subs {
+		md, err := ensureUserMaildir(conf, s.user, child(s.Mailbox(f.Name)))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, md)
+	}
+	return res, nil
+}
+
+// userMaildirPath returns the path of the mailbox in the maildir of the user
+// as configured in the dovecot_auth template.
+func userMaildirPath(conf *Config, user, mailbox string) string {
+	return filepath.Join(conf.HomeDir, user, "."+mailbox)
+}
+
+// ensureUserMaildir returns the mailbox in the maildir of the user.
+func ensureUserMaildir(conf *Config, user, mailbox string) (*maildir.Maildir, error) {
+	uid, _ := strconv.Atoi(conf.Uid)
+	gid, _ := strconv.Atoi(conf.Gid)
+	root, err := maildir.NewWithPerm(filepath.Join(conf.HomeDir, user), true, maildir.DefaultFilePerm, uid, gid)
+	if err != nil {
+		return nil, err
+	}
+	return root.Child(mailbox, true)
+}
+
+// deliver writes the mail to the first maildir and links it into the others,
+// so that all copies have the same file name. Mails with keywords are stored
+// in cur with the keyword flags of each maildir.
+func deliver(boxes []*maildir.Maildir, r io.Reader, keywords []string) (string, error) {
+	if len(boxes) == 0 {
+		return "", nil
+	}
+	file, err := boxes[0].CreateMail(r)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(file)
+	src := filepath.Join(boxes[0].Path, "new", name)
+	if dst := mailPath(boxes[0].Path, name, keywords); dst != src {
+		err = os.Rename(src, dst)
+		if err != nil {
+			return "", err
+		}
+		src = dst
+	}
+	for _, md := range boxes[1:] {
+		dst := mailPath(md.Path, name, keywords)
+		err = os.Link(src, dst)
+		if err != nil {
+			err = copyFile(src, dst)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return file, nil
+}
+
+// mailPath returns the path of the new mail name in the maildir at path. Mails
+// with keywords are stored in cur with the keyword letters as flags.
+func mailPath(path, name string, keywords []string) string {
+	if len(keywords) == 0 {
+		return filepath.Join(path, "new", name)
+	}
+	flags, err := keywordFlags(path, keywords)
+	if err != nil {
+		log.Println(err)
+	}
+	if flags == "" {
+		return filepath.Join(path, "new", name)
+	}
+	return filepath.Join(path, "cur", name+":2,"+flags)
+}
+
+// keywordFlags returns the flag letters of the keywords in the maildir at path.
+// New keywords are added to the dovecot-keywords file of the maildir, which
+// maps up to 26 keywords to the letters a to z.
+func keywordFlags(path string, keywords []string) (string, error) {
+	file := filepath.Join(path, "dovecot-keywords")
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var names [26]string
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		i, err := strconv.Atoi(parts[0])
+		if err == nil && i >= 0 && i < len(names) {
+			names[i] = parts[1]
+		}
+	}
+	var flags []byte
+	var changed bool
+	for _, k := range keywords {
+		i := keywordIndex(names[:], k)
+		if i < 0 {
+			i = keywordIndex(names[:], "")
+			if i < 0 {
+				// all letters are used
+				continue
+			}
+			names[i] = k
+			changed = true
+		}
+		flags = append(flags, byte('a'+i))
+	}
+	if changed {
+		var buf bytes.Buffer
+		for i, n := range names {
+			if n != "" {
+				fmt.Fprintf(&buf, "%d %s\n", i, n)
+			}
+		}
+		err = ioutil.WriteFile(file, buf.Bytes(), maildir.DefaultFilePerm)
+		if err != nil {
+			return "", err
+		}
+	}
+	sort.Sort(byteSlice(flags))
+	return string(flags), nil
+}
+
+func keywordIndex(names []string, k string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, k) {
+			return i
+		}
+	}
+	return -1
+}
+
+type byteSlice []byte
+
+func (b byteSlice) Len() int           { return len(b) }
+func (b byteSlice) Less(i, j int) bool { return b[i] < b[j] }
+func (b byteSlice) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// categoryPaths returns the path of the maildir and the paths of the category
+// folders created below it for feeder f.
+func categoryPaths(f feeds.Feeder, path string) []string {
+	res := make([]string, 0, 1+len(f.Subfolders))
+	res = append(res, path)
+	for _, name := range f.Subfolders {
+		res = append(res, path+"."+name)
+	}
+	return res
+}
+
+// categoryFolder returns the category folder of entry e for feeder f. New
+// folders are recorded for the feeder before they are created. If a maildir
+// with that name already exists, it is not ours and the entry stays in the
+// feed mailbox.
+func (p *prog) categoryFolder(db *sql.DB, f *feeds.Feeder, e feeds.Entry) (string, error) {
+	folder := e.CategoryFolder()
+	if folder == "" {
+		return "", nil
+	}
+	for _, name := range f.Subfolders {
+		if name == folder {
+			return folder, nil
+		}
+	}
+	paths := []string{mailboxPath(p.conf, f.Mailbox())}
+	subs, err := subscriberBoxes(db, f.Id)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range subs {
+		paths = append(paths, userMaildirPath(p.conf, s.user, s.Mailbox(f.Name)))
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path + "." + folder); err == nil {
+			p.report(*f, "category folder %s collides with %s", folder, path+"."+folder)
+			return "", nil
+		}
+	}
+	err = p.write(db, func(db *sql.DB) error {
+		return f.AddSubfolder(db, folder)
+	})
+	if err != nil {
+		return "", err
+	}
+	return folder, nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, maildir.DefaultFilePerm)
+}
+
+func ensureMaildir(conf *Config, name string) (*maildir.Maildir, error) {
+	uid, _ := strconv.Atoi(conf.Uid)
+	gid, _ := strconv.Atoi(conf.Gid)
+	root, err := maildir.NewWithPerm(conf.FeedsDir(), true, maildir.DefaultFilePerm, uid, gid)
+	if err != nil {
+		return nil, err
+	}
+	child, err := root.Child(name, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ensureFile(filepath.Join(child.Path, "dovecot-acl"), 0664, strings.NewReader("authenticated lrst\n"))
+	if err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// removeMail removes the message file from the maildir at path. The file is
+// looked up in new and cur by its unique name, ignoring the info suffix.
+func removeMail(path, file string) error {
+	name := strings.SplitN(filepath.Base(file), ":", 2)[0]
+	for _, sub := range []string{"new", "cur"} {
+		matches, err := filepath.Glob(filepath.Join(path, sub, name+"*"))
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			err = os.Remove(m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entryMsg returns the mail for entry e of feeder f. The mail has a plain text
+// and an html alternative with the embedded images as related parts and the
+// optional enclosure attachment.
+func entryMsg(f feeds.Feeder, e feeds.Entry, addr email.Addr, att *feeds.Attachment) (*email.Msg, error) {
+	subject := e.Title
+	if e.Prev != nil {
+		subject = "Updated: " + subject
+	}
+	m := email.NewMsg(addr, subject, addr)
+	dtime := e.PublishedTime()
+	if e.Prev != nil {
+		// use the update date if the feed has one
+		if u := e.UpdatedTime(); u.After(dtime) {
+			dtime = u
+		} else {
+			dtime = time.Now()
+		}
+	}
+	if dtime.IsZero() {
+		dtime = time.Now()
+	}
+	m.SetDate(dtime)
+	key := e.Key()
+	origId := email.MessageId("feeds", strconv.FormatInt(f.Id, 10), key)
+	if e.Prev != nil {
+		m.SetMessageId(email.MessageId("feeds", strconv.FormatInt(f.Id, 10), key, e.Hash()))
+		m.SetInReplyTo(origId)
+	} else {
+		m.SetMessageId(origId)
+	}
+	text, err := e.Text()
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.Html()
+	if err != nil {
+		return nil, err
+	}
+	err = setBody(m, f, text, r)
+	if err != nil {
+		return nil, err
+	}
+	if e.Stored != "" {
+		m.Header.Add(enclosureHeader, enclosureRef(e))
+	}
+	if att != nil {
+		alt, err := email.NewMultipart(m.Multipart, m.Parts...)
+		if err != nil {
+			return nil, err
+		}
+		m.Multipart = "mixed"
+		m.Parts = []email.Part{*alt}
+		err = m.AddAttachment(att.Type, att.Name, bytes.NewReader(att.Data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// setBody sets the plain text and html alternatives as message body. The images
+// of the html are embedded as related parts if enabled for feeder f.
+func setBody(m *email.Msg, f feeds.Feeder, text, r io.Reader) error {
+	var imgs []feeds.Image
+	var err error
+	if f.Images {
+		r, imgs, err = feeds.EmbedImages(&f.Fetcher, r)
+		if err != nil {
+			return err
+		}
+	}
+	plain, err := email.NewQuotedPrintable(email.PlainType, text)
+	if err != nil {
+		return err
+	}
+	part, err := email.NewQuotedPrintable(email.HtmlType, r)
+	if err != nil {
+		return err
+	}
+	if len(imgs) > 0 {
+		parts := []email.Part{*part}
+		for _, img := range imgs {
+			p, err := email.NewInline(img.Type, img.Cid, bytes.NewReader(img.Data))
+			if err != nil {
+				return err
+			}
+			parts = append(parts, *p)
+		}
+		part, err = email.NewMultipart("related", parts...)
+		if err != nil {
+			return err
+		}
+	}
+	m.Multipart = "alternative"
+	m.Parts = []email.Part{*plain, *part}
+	return nil
+}
+
+// digestMsg returns the digest mail with the entries of feeder f.
+func digestMsg(f feeds.Feeder, entries []feeds.Entry, addr email.Addr, now time.Time) (*email.Msg, error) {
+	title := fmt.Sprintf("%s %s digest", f.Name, feeds.FormatDigest(f.Digest))
+	m := email.NewMsg(addr, fmt.Sprintf("%s: %d entries", title, len(entries)), addr)
+	m.SetDate(now)
+	m.SetMessageId(email.MessageId("feeds", strconv.FormatInt(f.Id, 10), "digest", now.Format(time.RFC3339)))
+	text, err := feeds.DigestText(title, entries)
+	if err != nil {
+		return nil, err
+	}
+	r, err := feeds.DigestHtml(title, entries)
+	if err != nil {
+		return nil, err
+	}
+	err = setBody(m, f, text, r)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Stored != "" {
+			m.Header.Add(enclosureHeader, enclosureRef(e))
+		}
+	}
+	return m, nil
+}
+
+// enclosureHeader names the stored enclosures a mail refers to, so that they
+// can be removed with the last mail.
+const enclosureHeader = "X-Feed-Enclosure"
+
+// enclosureRef returns the name prefix of the stored enclosure of entry e.
+func enclosureRef(e feeds.Entry) string {
+	return e.Key()[:12]
+}
+
+// enclosureDir returns the directory of the stored enclosures of feeder f.
+func enclosureDir(conf *Config, f feeds.Feeder) string {
+	return filepath.Join(conf.HomeDir, "enclosures", f.Mailbox())
+}
+
+// mailEnclosures returns the stored enclosures the mail at path refers to.
+func mailEnclosures(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h, err := textproto.NewReader(bufio.NewReader(file)).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return h[enclosureHeader], nil
+}
+
+// storeEnclosure writes the enclosure of entry e next to the feed maildirs and
+// returns its path.
+func storeEnclosure(conf *Config, f feeds.Feeder, e feeds.Entry, att *feeds.Attachment) (string, error) {
+	dir := enclosureDir(conf, f)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, enclosureRef(e)+"-"+att.Name)
+	return path, ioutil.WriteFile(path, att.Data, maildir.DefaultFilePerm)
+}
+
+// prepare fetches the full content and the enclosure of entry e if enabled for
+// feeder f. It returns the enclosure if it should be attached and attach is true.
+func (p *prog) prepare(f feeds.Feeder, e *feeds.Entry, attach bool) *feeds.Attachment {
+	if f.Fulltext {
+		err := e.FetchContent(&f.Fetcher)
+		if err != nil {
+			// fall back to the feed content
+			p.report(f, "extracting %s: %v", e.Link, err)
+		}
+	}
+	switch {
+	case e.Enclosure.URL == "", f.Enclosures == feeds.EnclosureLink:
+		return nil
+	case f.Enclosures == feeds.EnclosureAttach && !attach:
+		return nil
+	}
+	att, err := e.FetchEnclosure(&f.Fetcher, f.MaxEnclosure)
+	if err != nil {
+		p.report(f, "enclosure %s: %v", e.Enclosure.URL, err)
+		return nil
+	}
+	if f.Enclosures == feeds.EnclosureStore {
+		e.Stored, err = storeEnclosure(p.conf, f, *e, att)
+		if err != nil {
+			p.report(f, "enclosure %s: %v", e.Enclosure.URL, err)
+			e.Stored = ""
+		}
+		return nil
+	}
+	return att
+}
+
+// digest queues the entries for the digest of feeder f and delivers the digest
+// if it is due. Pending entries are only marked as fed after the digest was
+// delivered. They are pruned if total, the number of entries in the feed, is
+// known.
+func (p *prog) digest(db *sql.DB, f feeds.Feeder, addr email.Addr, entries []feeds.Entry, total int) error {
+	pending, err := f.Pending(db)
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]string, len(pending))
+	for _, e := range pending {
+		queued[e.Key()] = e.Hash()
+	}
+	var queue []feeds.Entry
+	for _, e := range entries {
+		// entries stay unfed until the digest is delivered
+		if queued[e.Key()] == e.Hash() {
+			continue
+		}
+		p.prepare(f, &e, false)
+		queue = append(queue, e)
+	}
+	now := time.Now()
+	err = p.write(db, func(db *sql.DB) error {
+		return f.Queue(db, queue, now)
+	})
+	if err != nil {
+		return err
+	}
+	if !f.DigestDue(now) {
+		p.report(f, "queued %d entries for the %s digest", len(queue), feeds.FormatDigest(f.Digest))
+		return p.write(db, f.Save)
+	}
+	pending, err = f.Pending(db)
+	if err != nil {
+		return err
+	}
+	f.Digested = &now
+	if len(pending) == 0 {
+		p.report(f, "no entries for the digest")
+		return p.write(db, f.Save)
+	}
+	m, err := digestMsg(f, pending, addr, now)
+	if err != nil {
+		return err
+	}
+	boxes, err := feedMaildirs(p.conf, db, f, "")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	if err != nil {
+		return err
+	}
+	_, err = deliver(boxes, &buf, nil)
+	if err != nil {
+		return err
+	}
+	for i := range pending {
+		// the digest must not be removed by replaced updates
+		pending[i].File = ""
+	}
+	err = p.write(db, func(db *sql.DB) error {
+		err := f.Fed(db, pending, now)
+		if err != nil || total <= 0 {
+			return err
+		}
+		return f.Prune(db, f.PruneLimit(total))
+	})
+	if err != nil {
+		return err
+	}
+	p.report(f, "delivered digest with %d entries", len(pending))
+	return nil
+}
+
+func (p *prog) checkEntries(f feeds.Feeder) error {
+	addr, err := email.ParseAddr(fmt.Sprintf(`"%s" <%s@feeds>`, f.Name, f.Name))
+	if err != nil {
+		return err
+	}
+	entries, err := f.Entries()
+	if err != nil {
+		return err
+	}
+	total := len(entries)
+	db := open(p.conf)
+	defer db.Close()
+	if f.Moved != "" {
+		from := f.Url
+		err = p.write(db, f.Move)
+		if err != nil {
+			p.report(f, "moved permanently to %s but not updated: %v", f.Moved, err)
+		} else {
+			p.report(f, "moved permanently from %s to %s", from, f.Url)
+		}
+	}
+	if f.NotModified() {
+		p.report(f, "not modified")
+		if f.Digest != feeds.DigestOff {
+			return p.digest(db, f, addr, nil, 0)
+		}
+		return p.write(db, f.Save)
+	}
+	return p.feedEntries(db, f, addr, entries, total)
+}
+
+// push delivers the entries pushed by the hub of feeder f.
+func (p *prog) push(f feeds.Feeder, entries []feeds.Entry) error {
+	addr, err := email.ParseAddr(fmt.Sprintf(`"%s" <%s@feeds>`, f.Name, f.Name))
+	if err != nil {
+		return err
+	}
+	db := open(p.conf)
+	defer db.Close()
+	// pushed content may be only a part of the feed
+	return p.feedEntries(db, f, addr, entries, 0)
+}
+
+// feedEntries filters the entries of feeder f and delivers the new and updated
+// entries. Total is the number of entries in the feed, the fed entries are only
+// pruned if it is known.
+func (p *prog) feedEntries(db *sql.DB, f feeds.Feeder, addr email.Addr, entries []feeds.Entry, total int) error {
+	entries, err := f.Filter(db, entries)
+	if err != nil {
+		return err
+	}
+	rules, err := f.Rules(db)
+	if err != nil {
+		return err
+	}
+	entries = feeds.Apply(rules, entries)
+	if f.Digest != feeds.DigestOff {
+		return p.digest(db, f, addr, entries, total)
+	}
+	if len(entries) == 0 {
+		p.report(f, "no new entries")
+		return p.write(db, f.Save)
+	}
+	boxes, err := feedMaildirs(p.conf, db, f, "")
+	if err != nil {
+		return err
+	}
+	folders := map[string][]*maildir.Maildir{"": boxes}
+	var written []feeds.Entry
+	var updated int
+	for _, e := range entries {
+		var folder string
+		var keywords []string
+		if f.Categories&feeds.CategoryFolders != 0 {
+			folder, err = p.categoryFolder(db, &f, e)
+			if err != nil {
+				return err
+			}
+		}
+		if f.Categories&feeds.CategoryKeywords != 0 {
+			keywords = e.Keywords()
+		}
+		if _, ok := folders[folder]; !ok {
+			folders[folder], err = feedMaildirs(p.conf, db, f, folder)
+			if err != nil {
+				return err
+			}
+		}
+		att := p.prepare(f, &e, true)
+		m, err := entryMsg(f, e, addr, att)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var buf bytes.Buffer
+		_, err = m.WriteTo(&buf)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		e.File, err = deliver(folders[folder], &buf, keywords)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if e.Prev != nil {
+			updated++
+			if f.Updates == feeds.UpdateReplace && e.Prev.File != "" {
+				for _, md := range boxes {
+					// the previous version may be in another category folder
+					for _, path := range categoryPaths(f, md.Path) {
+						err = removeMail(path, e.Prev.File)
+						if err != nil {
+							log.Println(err)
+						}
+					}
+				}
+			}
+		}
+		written = append(written, e)
+	}
+	if len(written) < len(entries) {
+		// request the whole feed again to retry the failed entries
+		f.ETag, f.Modified = "", ""
+	}
+	err = p.write(db, func(db *sql.DB) error {
+		err := f.Fed(db, written, time.Now())
+		if err != nil || total <= 0 {
+			return err
+		}
+		return f.Prune(db, f.PruneLimit(total))
+	})
+	if err != nil {
+		return err
+	}
+	p.report(f, "got %d entries %d of them are new %d updated", len(entries), len(written)-updated, updated)
+	return nil
 }